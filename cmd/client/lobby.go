@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// errLeftLobby is returned when the player quits before joining a game
+var errLeftLobby = errors.New("left the lobby")
+
+// joinLobby announces the player and asks for commands until the lobby put them in a game.
+// The requester stays open so the player can leave the game when they quit.
+func joinLobby(conn pubsub.Broker, username string, rating int) (*pubsub.Requester, gamelogic.LobbyReply, error) {
+	lobby, err := pubsub.NewRequester(conn, routing.ExchangePerilDirect, routing.LobbyReplyQueue(username).Name)
+	if err != nil {
+		return nil, gamelogic.LobbyReply{}, err
+	}
+
+	reply, err := lobbyRequest(lobby, gamelogic.LobbyRequest{Kind: gamelogic.LobbyAnnounce, Username: username, Rating: rating})
+	if err != nil {
+		lobby.Close()
+		return nil, gamelogic.LobbyReply{}, err
+	}
+	// a player who was already in a game goes straight back to it
+	if reply.Game != nil {
+		return lobby, reply, nil
+	}
+	gamelogic.PrintLobbyGames(reply.Games)
+	gamelogic.PrintLobbyHelp()
+
+	for {
+		input := gamelogic.GetInput()
+		if len(input) == 0 {
+			continue
+		}
+		req := gamelogic.LobbyRequest{Username: username}
+		switch input[0] {
+		case "games":
+			req.Kind = gamelogic.LobbyList
+		case "create":
+			req.Kind = gamelogic.LobbyCreate
+			if len(input) >= 2 {
+				req.Cap, err = strconv.Atoi(input[1])
+				if err != nil {
+					fmt.Println("Wrong syntax, usage: create [players]")
+					continue
+				}
+			}
+		case "join":
+			if len(input) < 2 {
+				fmt.Println("Wrong syntax, usage: join <game>")
+				continue
+			}
+			req.Kind = gamelogic.LobbyJoin
+			req.GameID = input[1]
+		case "find":
+			req.Kind = gamelogic.LobbyFind
+		case "help":
+			gamelogic.PrintLobbyHelp()
+			continue
+		case "quit":
+			lobby.Close()
+			return nil, gamelogic.LobbyReply{}, errLeftLobby
+		default:
+			fmt.Println("Unknown command")
+			continue
+		}
+
+		reply, err = lobbyRequest(lobby, req)
+		if err != nil {
+			log.Println("The lobby refused: ", err)
+			continue
+		}
+		if reply.Game != nil {
+			return lobby, reply, nil
+		}
+		gamelogic.PrintLobbyGames(reply.Games)
+	}
+}
+
+// leaveLobby frees the player's seat in the game, the lobby forgets it otherwise only when an admin closes the game
+func leaveLobby(lobby *pubsub.Requester, username string) {
+	_, err := lobbyRequest(lobby, gamelogic.LobbyRequest{Kind: gamelogic.LobbyLeave, Username: username})
+	if err != nil {
+		log.Println("Failed to leave the lobby: ", err)
+	}
+	lobby.Close()
+}
+
+func lobbyRequest(lobby *pubsub.Requester, req gamelogic.LobbyRequest) (gamelogic.LobbyReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishConfirmTimeout)
+	defer cancel()
+
+	reply, err := pubsub.Request[gamelogic.LobbyRequest, gamelogic.LobbyReply](ctx, lobby, routing.LobbyKey, pubsub.ContentTypeJSON, req)
+	var returnedErr *pubsub.ReturnedError
+	if errors.As(err, &returnedErr) {
+		return gamelogic.LobbyReply{}, fmt.Errorf("the request was not delivered, is the lobby running? %w", err)
+	}
+	return reply, err
+}
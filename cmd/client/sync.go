@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// syncState asks the server of the game for the player's state as its world has it
+func syncState(syncer *pubsub.Requester, game routing.Game, username string) (gamelogic.Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishConfirmTimeout)
+	defer cancel()
+
+	snap, err := pubsub.Request[gamelogic.SyncRequest, gamelogic.Snapshot](ctx, syncer, game.SyncKey(), pubsub.ContentTypeJSON, gamelogic.SyncRequest{Username: username})
+	var returnedErr *pubsub.ReturnedError
+	if errors.As(err, &returnedErr) {
+		return gamelogic.Snapshot{}, fmt.Errorf("the request was not delivered, is the server running? %w", err)
+	}
+	return snap, err
+}
+
+// loadGame reads a saved snapshot and reconciles it with the server, whose world is what
+// counts. Without an answer from the server nothing is loaded, the client would not
+// agree with it anymore.
+func loadGame(gs *gamelogic.GameState, syncer *pubsub.Requester, game routing.Game, path string) error {
+	loaded, err := gamelogic.ReadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	server, err := syncState(syncer, game, gs.GetUsername())
+	if err != nil {
+		return err
+	}
+	gone, err := gs.Reconcile(loaded, server)
+	if err != nil {
+		return err
+	}
+	if len(gone) > 0 {
+		fmt.Printf("The server no longer has %v unit(s) of the snapshot:\n", len(gone))
+		for _, unit := range gone {
+			fmt.Printf("* %v: %v in %v\n", unit.ID, unit.Rank, unit.Location)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+func main() {
+	unitsPath := flag.String("units", "", "unit catalog the game was played with, the three classic ranks when empty")
+	flag.Parse()
+	args := flag.Args()
+
+	catalog := gamelogic.DefaultCatalog()
+	if *unitsPath != "" {
+		var err error
+		catalog, err = gamelogic.LoadCatalog(*unitsPath)
+		if err != nil {
+			log.Fatal("Failed to load the unit catalog: ", err)
+		}
+	}
+
+	if len(args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	events, err := gamelogic.ReadEvents(args[1])
+	if err != nil {
+		log.Fatal("Failed to read the event log: ", err)
+	}
+
+	switch args[0] {
+	case "list":
+		for _, e := range events {
+			printEvent(e)
+		}
+
+	case "show":
+		if len(args) < 3 {
+			printUsage()
+			os.Exit(1)
+		}
+		until := int64(len(events))
+		if len(events) > 0 {
+			until = events[len(events)-1].Seq
+		}
+		if len(args) > 3 {
+			until, err = parsePoint(args[3], events)
+			if err != nil {
+				fmt.Println(err)
+				printUsage()
+				os.Exit(1)
+			}
+		}
+		gs := gamelogic.Replay(events, args[2], catalog, until)
+		fmt.Printf("After event %v:\n", until)
+		printState(gs)
+
+	case "step":
+		if len(args) < 3 {
+			printUsage()
+			os.Exit(1)
+		}
+		step(events, args[2], catalog)
+
+	default:
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("* replay [-units <catalog>] list <event log>")
+	fmt.Println("* replay [-units <catalog>] show <event log> <username> [<seq> | <RFC3339 time>]")
+	fmt.Println("* replay [-units <catalog>] step <event log> <username>")
+	fmt.Println("    example:")
+	fmt.Println("    replay show events/server.jsonl alice 2024-05-01T18:30:00Z")
+}
+
+// parsePoint turns a sequence number or a time into the last event at or before it
+func parsePoint(arg string, events []gamelogic.Event) (int64, error) {
+	seq, err := strconv.ParseInt(arg, 10, 64)
+	if err == nil {
+		return seq, nil
+	}
+	at, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return 0, fmt.Errorf("error: %s is neither an event number nor an RFC3339 time", arg)
+	}
+	i := sort.Search(len(events), func(i int) bool { return events[i].Time.After(at) })
+	if i == 0 {
+		return 0, nil
+	}
+	return events[i-1].Seq, nil
+}
+
+// step applies one event per line read from stdin and shows the state after it
+func step(events []gamelogic.Event, username string, catalog *gamelogic.Catalog) {
+	gs := gamelogic.NewGameState(username)
+	gs.SetCatalog(catalog)
+	fmt.Println("Press enter for the next event, q to stop")
+	for _, e := range events {
+		printEvent(e)
+		gs.Apply(e)
+		printState(gs)
+
+		input := gamelogic.GetInput()
+		if len(input) > 0 && input[0] == "q" {
+			return
+		}
+	}
+	fmt.Println("End of the event log")
+}
+
+func printEvent(e gamelogic.Event) {
+	fmt.Printf("%v %v %v", e.Seq, e.Time.Format(time.RFC3339), e.Kind)
+	if e.Username != "" {
+		fmt.Printf(" by %v", e.Username)
+	}
+	if e.Location != "" {
+		fmt.Printf(" in %v", e.Location)
+	}
+	for _, u := range e.Units {
+		fmt.Printf(" %v(%v)", u.Key(), u.Rank)
+	}
+	if e.War != nil {
+		fmt.Printf(" %v against %v", e.War.Attacker.Username, e.War.Defender.Username)
+	}
+	fmt.Println()
+}
+
+func printState(gs *gamelogic.GameState) {
+	p := gs.GetPlayerSnap()
+	ids := []int{}
+	for id := range p.Units {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	fmt.Printf("  %v has %v units, paused: %v\n", p.Username, len(ids), gs.Paused)
+	for _, id := range ids {
+		unit := p.Units[id]
+		fmt.Printf("  * %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+	}
+}
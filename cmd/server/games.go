@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// gameOptions are the same for every game the server hosts
+type gameOptions struct {
+	gameMap *gamelogic.Map
+	catalog *gamelogic.Catalog
+	combat  gamelogic.CombatMode
+	victory gamelogic.VictoryCondition
+}
+
+// hostedGame is everything the server runs for one game: its own world, files, queues and turns
+type hostedGame struct {
+	game          routing.Game
+	world         *gamelogic.World
+	worldPath     string
+	events        *gamelogic.FileEventLog
+	turns         *turnEngine
+	subscriptions []*pubsub.Subscription
+}
+
+// parseGames reads the -games flag, the default game when it is empty
+func parseGames(value string) ([]routing.Game, error) {
+	if value == "" {
+		return []routing.Game{{}}, nil
+	}
+	games := []routing.Game{}
+	seen := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		game, err := routing.NewGame(strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+		if seen[game.String()] {
+			return nil, fmt.Errorf("error: game %v is listed twice", game)
+		}
+		seen[game.String()] = true
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+// serverName keeps the default game's files where they were before there were several games
+func serverName(game routing.Game) string {
+	if game.ID == "" {
+		return "server"
+	}
+	return "server-" + game.ID
+}
+
+func hostGame(ctx context.Context, conn pubsub.Broker, game routing.Game, options gameOptions, logSink gamelogic.LogSink) (*hostedGame, error) {
+	err := pubsub.ApplyTopology(conn, game.Topology())
+	if err != nil {
+		return nil, fmt.Errorf("could not apply the topology: %v", err)
+	}
+
+	// the server owns every player's units, clients only send commands
+	world := gamelogic.NewWorld()
+	if options.gameMap != nil {
+		world.SetMap(options.gameMap)
+	}
+	if options.catalog != nil {
+		world.SetCatalog(options.catalog)
+	}
+	world.SetCombatMode(options.combat)
+	err = world.SetVictory(options.victory)
+	if err != nil {
+		return nil, err
+	}
+
+	// every change the world makes is kept for cmd/replay, loading the snapshot included
+	events, err := gamelogic.OpenEventLog(gamelogic.EventLogPath(serverName(game)))
+	if err != nil {
+		return nil, fmt.Errorf("could not open the event log: %v", err)
+	}
+	world.RecordEvents(events)
+
+	h := &hostedGame{
+		game:      game,
+		world:     world,
+		worldPath: gamelogic.SnapshotPath(serverName(game), "world"),
+		events:    events,
+	}
+	if _, err := os.Stat(h.worldPath); err == nil {
+		err = world.Load(h.worldPath)
+		if err != nil {
+			events.Close()
+			return nil, fmt.Errorf("could not load the world: %v", err)
+		}
+		log.Printf("Loaded the %v game from %v\n", game, h.worldPath)
+	}
+	go autosave(world, h.worldPath)
+
+	h.turns = newTurnEngine(game, world, conn)
+	go collectIncome(ctx, game, world, conn, incomeInterval)
+	go watchMatch(ctx, game, world, conn, h.turns)
+
+	commands := game.CommandsBinding()
+	commandSub, err := pubsub.SubscribeDelivery(conn, commands.Exchange, commands.Queue, commands.Key, pubsub.DurableQueue, handlerCommands(game, world, conn),
+		pubsub.WithPoisonPolicy(pubsub.PoisonPolicy{Action: pubsub.PoisonQuarantine}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to commands: %v", err)
+	}
+	h.subscriptions = append(h.subscriptions, commandSub)
+
+	// the world keeps the same treaties as the players so allies never fight
+	diplomacy := game.WorldDiplomacyBinding()
+	diplomacySub, err := pubsub.SubscribeDelivery(conn, diplomacy.Exchange, diplomacy.Queue, diplomacy.Key, pubsub.DurableQueue, handlerDiplomacy(game, world, conn),
+		pubsub.WithPoisonPolicy(pubsub.PoisonPolicy{Action: pubsub.PoisonQuarantine}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to diplomacy: %v", err)
+	}
+	h.subscriptions = append(h.subscriptions, diplomacySub)
+
+	// the lobby asks for the state of the players it puts in the game
+	allocate := game.AllocateBinding()
+	allocateSub, err := pubsub.Serve(conn, allocate.Exchange, allocate.Queue, allocate.Key, pubsub.DurableQueue, handlerAllocate(game, world, conn, h.turns),
+		pubsub.WithPoisonPolicy(pubsub.PoisonPolicy{Action: pubsub.PoisonQuarantine}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not serve the lobby: %v", err)
+	}
+	h.subscriptions = append(h.subscriptions, allocateSub)
+
+	// clients ask for their state after loading a snapshot of their own
+	sync := game.SyncBinding()
+	syncSub, err := pubsub.Serve(conn, sync.Exchange, sync.Queue, sync.Key, pubsub.DurableQueue, handlerSync(world),
+		pubsub.WithPoisonPolicy(pubsub.PoisonPolicy{Action: pubsub.PoisonQuarantine}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not serve the clients' state: %v", err)
+	}
+	h.subscriptions = append(h.subscriptions, syncSub)
+
+	// every game's logs go to the same files, marked with the game
+	logs := game.GameLogsBinding()
+	logSub, err := pubsub.Subscribe(conn, logs.Exchange, logs.Queue, logs.Key, pubsub.DurableQueue, handlerLogs(game, logSink),
+		pubsub.WithPoisonPolicy(pubsub.PoisonPolicy{Action: pubsub.PoisonQuarantine}),
+		// logs are handled in parallel but still in order per player
+		pubsub.WithWorkers(logWorkers),
+		pubsub.WithOrderedByKey(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to game logs: %v", err)
+	}
+	h.subscriptions = append(h.subscriptions, logSub)
+	return h, nil
+}
+
+// stop ends the turns and the subscriptions, the world is saved after the logs are closed
+func (h *hostedGame) stop(ctx context.Context) {
+	h.turns.stop()
+	for _, sub := range h.subscriptions {
+		err := sub.Close(ctx)
+		if err != nil {
+			log.Printf("Failed to close subscription of the %v game: %v\n", h.game, err)
+		}
+	}
+}
+
+func (h *hostedGame) save() {
+	saveWorld(h.world, h.worldPath)
+	err := h.events.Close()
+	if err != nil {
+		log.Printf("Failed to close the event log of the %v game: %v\n", h.game, err)
+	}
+}
+
+func printGames(games []*hostedGame, current *hostedGame) {
+	for _, h := range games {
+		marker := " "
+		if h == current {
+			marker = "*"
+		}
+		ms := h.world.Match()
+		fmt.Printf("%v %v: %v player(s), match %v, turn %v\n", marker, h.game, len(h.world.Usernames()), ms.Phase, h.world.Turn())
+	}
+}
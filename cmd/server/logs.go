@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const defaultLogsLimit = 50
+
+// parseLogQuery reads "logs [user=<name>] [game=<id>] [from=<time>] [to=<time>] [limit=<n>] [text...]",
+// a time is either RFC3339 or a duration before now, like from=15m
+func parseLogQuery(words []string) (gamelogic.LogQuery, error) {
+	q := gamelogic.LogQuery{Limit: defaultLogsLimit}
+	text := []string{}
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok {
+			text = append(text, word)
+			continue
+		}
+
+		var err error
+		switch key {
+		case "user":
+			q.Username = value
+		case "game":
+			q.Game = value
+		case "from":
+			q.From, err = parseLogTime(value)
+		case "to":
+			q.To, err = parseLogTime(value)
+		case "limit":
+			q.Limit, err = strconv.Atoi(value)
+		default:
+			text = append(text, word)
+		}
+		if err != nil {
+			return gamelogic.LogQuery{}, fmt.Errorf("error: %s is not a valid %s", value, key)
+		}
+	}
+	q.Text = strings.Join(text, " ")
+	return q, nil
+}
+
+func parseLogTime(value string) (time.Time, error) {
+	ago, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printLogs(logs []routing.GameLog) {
+	if len(logs) == 0 {
+		fmt.Println("No logs found")
+		return
+	}
+	for _, gamelog := range logs {
+		game := ""
+		if gamelog.Game != "" {
+			game = " [" + gamelog.Game + "]"
+		}
+		fmt.Printf("%v%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), game, gamelog.Username, gamelog.Message)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type failingSink struct{}
+
+func (failingSink) Write(routing.GameLog) error { return errors.New("disk full") }
+func (failingSink) Close() error                { return nil }
+
+func TestHandlerLogsWritesBeforeAck(t *testing.T) {
+	dir := t.TempDir()
+	options := gamelogic.DefaultFileLogSinkOptions()
+	options.Dir = dir
+	options.FlushInterval = 0
+	options.Fsync = gamelogic.FsyncWrite
+	sink, err := gamelogic.NewFileLogSink(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	game := routing.Game{ID: "test"}
+
+	ack := handlerLogs(game, sink)(routing.GameLog{CurrentTime: time.Now(), Username: "alice", Message: "hello"})
+	if ack != pubsub.Ack {
+		t.Fatalf("ack = %v, want Ack", ack)
+	}
+	// the sink is still open, the log has to be in the file already
+	logs, err := gamelogic.QueryLogs(dir, gamelogic.LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Message != "hello" || logs[0].Game != "test" {
+		t.Errorf("logs = %+v, want alice's log of the test game", logs)
+	}
+
+	ack = handlerLogs(game, failingSink{})(routing.GameLog{CurrentTime: time.Now(), Username: "alice", Message: "lost"})
+	if ack != pubsub.Retry(logRetryDelay) {
+		t.Errorf("ack = %v, want a retry when the log was not written", ack)
+	}
+}
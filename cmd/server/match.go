@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const (
+	matchCheckInterval = time.Second
+	// matchAnnounceInterval is how long a client that joined late waits to hear about the match
+	matchAnnounceInterval = 5 * time.Second
+)
+
+// watchMatch starts the match when the countdown is over, finishes it once someone won
+// and keeps announcing it for the clients that joined late
+func watchMatch(ctx context.Context, game routing.Game, world *gamelogic.World, conn pubsub.Broker, turns *turnEngine) {
+	ticker := time.NewTicker(matchCheckInterval)
+	defer ticker.Stop()
+	lastAnnounced := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+
+		ms := world.Match()
+		switch ms.Phase {
+		case routing.MatchPhaseStarting:
+			if now.Before(ms.StartsAt) {
+				break
+			}
+			started, err := world.StartMatch(now)
+			if err != nil {
+				log.Println("Failed to start the match: ", err)
+				continue
+			}
+			log.Printf("The match has started, victory: %v\n", started.Victory)
+			publishMatch(ctx, game, conn, started)
+			lastAnnounced = now
+			continue
+		case routing.MatchPhaseInProgress:
+			finished, ok := world.CheckVictory(now)
+			if !ok {
+				break
+			}
+			finishMatch(ctx, game, conn, turns, finished)
+			lastAnnounced = now
+			continue
+		}
+
+		if now.Sub(lastAnnounced) >= matchAnnounceInterval {
+			publishMatch(ctx, game, conn, ms)
+			lastAnnounced = now
+		}
+	}
+}
+
+// finishMatch stops the turns, tells everyone and keeps the final standings in the game log
+func finishMatch(ctx context.Context, game routing.Game, conn pubsub.Broker, turns *turnEngine, ms routing.MatchState) {
+	turns.stop()
+	publishMatch(ctx, game, conn, ms)
+	printMatch(ms)
+
+	result := "nobody won"
+	if ms.Winner != "" {
+		result = ms.Winner + " won"
+	}
+	for i, s := range ms.Standings {
+		message := fmt.Sprintf("Match over, %v (%v): %v finished #%v of %v with a score of %v, %v location(s) and %v unit(s)",
+			result, ms.Reason, s.Username, i+1, len(ms.Standings), s.Score, s.Locations, s.Units)
+		gameLog := routing.GameLog{CurrentTime: time.Now(), Message: message, Username: s.Username, Game: game.String()}
+		err := pubsub.Publish(ctx, conn, routing.ExchangePerilTopic, game.GameLogKey(s.Username), pubsub.ContentTypeGob, gameLog)
+		if err != nil {
+			log.Println("Failed to publish the standings: ", err)
+		}
+	}
+}
+
+// handlerAllocate answers the lobby once it put a player in the game. A game the lobby hands
+// out again is reset first, a match its last players left running is ended for them.
+func handlerAllocate(game routing.Game, world *gamelogic.World, conn pubsub.Broker, turns *turnEngine) func(gamelogic.AllocateRequest) (gamelogic.Snapshot, error) {
+	return func(req gamelogic.AllocateRequest) (gamelogic.Snapshot, error) {
+		defer fmt.Println("> ")
+		ctx := context.Background()
+		if req.Reset {
+			if world.Match().Phase == routing.MatchPhaseInProgress {
+				ms, err := world.EndMatch()
+				if err == nil {
+					finishMatch(ctx, game, conn, turns, ms)
+				}
+			}
+			ms, err := world.ResetMatch()
+			if err != nil {
+				return gamelogic.Snapshot{}, err
+			}
+			publishMatch(ctx, game, conn, ms)
+			log.Printf("The lobby handed out the %v game again, the world is reset\n", game)
+		}
+		log.Printf("The lobby put %v in the %v game\n", req.Username, game)
+		return world.PlayerSnapshot(req.Username), nil
+	}
+}
+
+// handlerSync answers a client that asks for its state, like after it loaded a snapshot.
+// The username is not authentication, like the key of a command.
+func handlerSync(world *gamelogic.World) func(gamelogic.SyncRequest) (gamelogic.Snapshot, error) {
+	return func(req gamelogic.SyncRequest) (gamelogic.Snapshot, error) {
+		if req.Username == "" {
+			return gamelogic.Snapshot{}, errors.New("error: a sync request needs a username")
+		}
+		return world.PlayerSnapshot(req.Username), nil
+	}
+}
+
+func publishMatch(ctx context.Context, game routing.Game, conn pubsub.Broker, ms routing.MatchState) {
+	err := pubsub.Publish(ctx, conn, routing.ExchangePerilDirect, game.MatchKey(), pubsub.ContentTypeJSON, ms)
+	if err != nil {
+		log.Println("Failed to publish the match: ", err)
+	}
+}
+
+func printMatch(ms routing.MatchState) {
+	fmt.Printf("The match is %v, victory: %v\n", ms.Phase, ms.Victory)
+	switch ms.Phase {
+	case routing.MatchPhaseStarting:
+		fmt.Printf("It starts at %v\n", ms.StartsAt.Format(time.TimeOnly))
+	case routing.MatchPhaseInProgress:
+		if !ms.EndsAt.IsZero() {
+			fmt.Printf("It ends at %v\n", ms.EndsAt.Format(time.TimeOnly))
+		}
+	case routing.MatchPhaseFinished:
+		if ms.Winner != "" {
+			fmt.Printf("%v won: %v\n", ms.Winner, ms.Reason)
+		} else {
+			fmt.Printf("Nobody won: %v\n", ms.Reason)
+		}
+		for i, s := range ms.Standings {
+			fmt.Printf("%v. %v: score %v, %v location(s), %v unit(s)\n", i+1, s.Username, s.Score, s.Locations, s.Units)
+		}
+	}
+}
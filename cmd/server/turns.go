@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// turnEngine announces a turn, waits for its deadline, resolves the orders and starts the next one
+type turnEngine struct {
+	game  routing.Game
+	world *gamelogic.World
+	conn  pubsub.Broker
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newTurnEngine(game routing.Game, world *gamelogic.World, conn pubsub.Broker) *turnEngine {
+	return &turnEngine{game: game, world: world, conn: conn}
+}
+
+func (e *turnEngine) start(length time.Duration) {
+	e.stop()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go e.run(ctx, length, e.done)
+}
+
+// stop goes back to real time, the orders of the current turn are dropped
+func (e *turnEngine) stop() {
+	e.mu.Lock()
+	cancel, done := e.cancel, e.done
+	e.cancel, e.done = nil, nil
+	e.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+	e.world.StopTurns()
+	publishTurn(context.Background(), e.game, e.conn, routing.TurnState{Phase: routing.TurnPhaseOff})
+}
+
+func (e *turnEngine) run(ctx context.Context, length time.Duration, done chan struct{}) {
+	defer close(done)
+	for {
+		turn := e.world.StartTurn()
+		deadline := time.Now().Add(length)
+		log.Printf("Turn %v started, it ends at %v\n", turn, deadline.Format(time.TimeOnly))
+		publishTurn(ctx, e.game, e.conn, routing.TurnState{Turn: turn, Phase: routing.TurnPhaseOrders, Deadline: deadline})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(length):
+		}
+
+		result := e.world.ResolveTurn()
+		log.Printf("Turn %v resolved: %v move(s), %v war(s)\n", turn, len(result.Moves), len(result.Wars))
+		publishTurnResult(ctx, e.game, e.conn, e.world, result)
+		publishTurn(ctx, e.game, e.conn, routing.TurnState{Turn: turn, Phase: routing.TurnPhaseResolved})
+	}
+}
+
+func publishTurn(ctx context.Context, game routing.Game, conn pubsub.Broker, ts routing.TurnState) {
+	err := pubsub.Publish(ctx, conn, routing.ExchangePerilDirect, game.TurnKey(), pubsub.ContentTypeJSON, ts)
+	if err != nil {
+		log.Println("Failed to publish the turn: ", err)
+	}
+}
+
+// publishTurnResult tells the players what happened in the same way real time commands do
+func publishTurnResult(ctx context.Context, game routing.Game, conn pubsub.Broker, world *gamelogic.World, result gamelogic.TurnResult) {
+	for username, reasons := range result.Rejected {
+		for _, reason := range reasons {
+			publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: username, Rejected: reason})
+		}
+	}
+	for username, units := range result.Spawned {
+		publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: username, Spawned: units})
+	}
+	for _, move := range result.Moves {
+		publishMove(ctx, game, conn, move)
+	}
+	for _, rw := range result.Wars {
+		publishWar(ctx, game, conn, world.Catalog(), rw)
+	}
+	// spawns were paid for and income earned, so everyone gets their resources
+	for _, username := range world.Usernames() {
+		resources := world.Resources(username)
+		publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: username, Resources: &resources})
+	}
+}
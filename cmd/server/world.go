@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// handlerCommands checks every command against the world and tells the players what
+// actually happened. The world has already changed once a command is applied, so a
+// failed publish is logged instead of retried, a retry would apply the command twice.
+// A command is only taken from the player whose key it was published on. The key is
+// not authentication, any client that may publish on the exchange can publish on any
+// player's key, so it only stops a command for someone else on your own key. Limiting
+// each player's broker user to their own keys is what keeps them off other keys.
+func handlerCommands(game routing.Game, world *gamelogic.World, conn pubsub.Broker) func(gamelogic.CommandRequest, amqp.Delivery) pubsub.AckType {
+	return func(cmd gamelogic.CommandRequest, delivery amqp.Delivery) pubsub.AckType {
+		defer fmt.Println("> ")
+		ctx := context.Background()
+
+		sender, ok := game.Sender(routing.CommandsPrefix, delivery.RoutingKey)
+		if !ok || sender != cmd.Username {
+			log.Printf("Refused a command for %v published on %v\n", cmd.Username, delivery.RoutingKey)
+			return pubsub.NackDiscard
+		}
+
+		// in turn mode commands are orders that wait for the end of the turn
+		if world.Turn() != 0 {
+			err := world.QueueOrder(cmd)
+			if err != nil {
+				publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: cmd.Username, Rejected: err.Error()})
+				return pubsub.Ack
+			}
+			publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: cmd.Username, Queued: &cmd})
+			return pubsub.Ack
+		}
+
+		switch cmd.Kind {
+		case gamelogic.CommandKindSpawn:
+			unit, err := world.Spawn(cmd.Username, cmd.Location, cmd.Rank)
+			if err != nil {
+				publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: cmd.Username, Rejected: err.Error()})
+				return pubsub.Ack
+			}
+			log.Printf("%v spawned a(n) %v in %v\n", cmd.Username, unit.Rank, unit.Location)
+			resources := world.Resources(cmd.Username)
+			publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: cmd.Username, Spawned: []gamelogic.Unit{unit}, Resources: &resources})
+
+		case gamelogic.CommandKindMove:
+			move, wars, err := world.Move(cmd.Username, cmd.Location, cmd.UnitIDs)
+			if err != nil {
+				publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: cmd.Username, Rejected: err.Error()})
+				return pubsub.Ack
+			}
+			log.Printf("%v moved %v unit(s) to %v\n", cmd.Username, len(move.Units), move.ToLocation)
+			publishMove(ctx, game, conn, move)
+
+			for _, rw := range wars {
+				publishWar(ctx, game, conn, world.Catalog(), rw)
+			}
+
+		default:
+			log.Printf("Unknown command %q from %v\n", cmd.Kind, cmd.Username)
+			return pubsub.NackDiscard
+		}
+		return pubsub.Ack
+	}
+}
+
+// publishMove tells the player its units moved and shows the units that moved
+// to the players who could see where they went, on each observer's own key
+func publishMove(ctx context.Context, game routing.Game, conn pubsub.Broker, move gamelogic.AcceptedMove) {
+	username := move.Player.Username
+	publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: username, Moved: move.Units})
+
+	redacted := gamelogic.RedactMove(move.ArmyMove)
+	for _, observer := range move.Observers {
+		err := pubsub.Publish(ctx, conn, routing.ExchangePerilTopic, game.ArmyMovesKey(observer), pubsub.ContentTypeJSON, redacted)
+		if err != nil {
+			log.Printf("Failed to publish the move to %v: %v\n", observer, err)
+		}
+	}
+}
+
+// collectIncome pays every player in real time, in turns income is paid when a turn is resolved
+func collectIncome(ctx context.Context, game routing.Game, world *gamelogic.World, conn pubsub.Broker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if world.Turn() != 0 || world.Paused() || world.Match().Phase != routing.MatchPhaseInProgress {
+			continue
+		}
+		publishIncome(ctx, game, world, conn, world.Tick())
+	}
+}
+
+func publishIncome(ctx context.Context, game routing.Game, world *gamelogic.World, conn pubsub.Broker, earned map[string]int) {
+	for username := range earned {
+		resources := world.Resources(username)
+		publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: username, Resources: &resources})
+	}
+}
+
+func publishDelta(ctx context.Context, game routing.Game, conn pubsub.Broker, delta gamelogic.StateDelta) {
+	err := pubsub.Publish(ctx, conn, routing.ExchangePerilTopic, game.StateKey(delta.Username), pubsub.ContentTypeJSON, delta)
+	if err != nil {
+		log.Printf("Failed to publish state delta for %v: %v\n", delta.Username, err)
+	}
+}
+
+// publishWar sends the war to both sides so they can show it and drop their losses,
+// the world has already dropped them
+func publishWar(ctx context.Context, game routing.Game, conn pubsub.Broker, catalog *gamelogic.Catalog, rw gamelogic.RecognitionOfWar) {
+	publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: rw.Attacker.Username, War: &rw})
+	publishDelta(ctx, game, conn, gamelogic.StateDelta{Username: rw.Defender.Username, War: &rw})
+
+	result, ok := gamelogic.ResolveWar(rw, catalog)
+	if !ok {
+		return
+	}
+	message := fmt.Sprintf("%v won a war against %v", result.Winner, result.Loser)
+	if result.Draw {
+		message = fmt.Sprintf("A war between %v and %v resulted in a draw", result.Winner, result.Loser)
+	}
+
+	gameLog := routing.GameLog{CurrentTime: time.Now(), Message: message, Username: rw.Attacker.Username, Game: game.String()}
+	err := pubsub.Publish(ctx, conn, routing.ExchangePerilTopic, game.GameLogKey(rw.Attacker.Username), pubsub.ContentTypeGob, gameLog)
+	if err != nil {
+		log.Println("Failed to publish war log: ", err)
+	}
+}
+
+// handlerDiplomacy applies the treaties to the world and logs every alliance that is made or broken.
+// Messages the world refuses are acked, the players' states refuse them the same way.
+// A message is only taken from the player whose key it was published on.
+func handlerDiplomacy(game routing.Game, world *gamelogic.World, conn pubsub.Broker) func(gamelogic.DiplomacyMessage, amqp.Delivery) pubsub.AckType {
+	return func(msg gamelogic.DiplomacyMessage, delivery amqp.Delivery) pubsub.AckType {
+		defer fmt.Println("> ")
+		sender, ok := game.Sender(routing.DiplomacyPrefix, delivery.RoutingKey)
+		if !ok || sender != msg.From {
+			log.Printf("Refused %v from %v published on %v\n", msg.Kind, msg.From, delivery.RoutingKey)
+			return pubsub.NackDiscard
+		}
+
+		changed, err := world.ApplyDiplomacy(msg)
+		if err != nil {
+			log.Printf("Refused %v from %v: %v\n", msg.Kind, msg.From, err)
+			return pubsub.Ack
+		}
+		if !changed {
+			return pubsub.Ack
+		}
+
+		message := fmt.Sprintf("%v and %v are allies", msg.To, msg.From)
+		switch msg.Kind {
+		case gamelogic.DiplomacyAcceptTruce:
+			message = fmt.Sprintf("%v and %v are in a truce", msg.To, msg.From)
+		case gamelogic.DiplomacyBreakAlliance:
+			message = fmt.Sprintf("%v broke the peace with %v", msg.From, msg.To)
+		}
+		log.Println(message)
+
+		gameLog := routing.GameLog{CurrentTime: time.Now(), Message: message, Username: msg.From, Game: game.String()}
+		err = pubsub.Publish(context.Background(), conn, routing.ExchangePerilTopic, game.GameLogKey(msg.From), pubsub.ContentTypeGob, gameLog)
+		if err != nil {
+			log.Println("Failed to publish diplomacy log: ", err)
+		}
+		return pubsub.Ack
+	}
+}
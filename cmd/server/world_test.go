@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const testTimeout = 2 * time.Second
+
+// testClient is a player wired up to the broker like cmd/client does it
+type testClient struct {
+	t      *testing.T
+	conn   pubsub.Broker
+	game   routing.Game
+	gs     *gamelogic.GameState
+	deltas chan gamelogic.StateDelta
+	moves  chan gamelogic.ArmyMove
+}
+
+func newTestClient(t *testing.T, conn pubsub.Broker, game routing.Game, username string, ms routing.MatchState) *testClient {
+	t.Helper()
+	c := &testClient{
+		t:      t,
+		conn:   conn,
+		game:   game,
+		gs:     gamelogic.NewGameState(username),
+		deltas: make(chan gamelogic.StateDelta, 10),
+		moves:  make(chan gamelogic.ArmyMove, 10),
+	}
+	c.gs.HandleMatch(ms)
+
+	state := game.StateBinding(username)
+	stateSub, err := pubsub.Subscribe(conn, state.Exchange, state.Queue, state.Key, pubsub.TransientQueue, func(delta gamelogic.StateDelta) pubsub.AckType {
+		c.gs.HandleDelta(delta)
+		c.deltas <- delta
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stateSub.Close(context.Background()) })
+
+	moves := game.ArmyMovesBinding(username)
+	moveSub, err := pubsub.Subscribe(conn, moves.Exchange, moves.Queue, moves.Key, pubsub.TransientQueue, func(move gamelogic.ArmyMove) pubsub.AckType {
+		c.gs.HandleMove(move)
+		c.moves <- move
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { moveSub.Close(context.Background()) })
+	return c
+}
+
+// send publishes the command on the player's own key, like the client does
+func (c *testClient) send(cmd gamelogic.CommandRequest, err error) {
+	c.t.Helper()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	err = pubsub.Publish(context.Background(), c.conn, routing.ExchangePerilTopic, c.game.CommandsKey(c.gs.GetUsername()), pubsub.ContentTypeJSON, cmd)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) delta() gamelogic.StateDelta {
+	c.t.Helper()
+	select {
+	case delta := <-c.deltas:
+		return delta
+	case <-time.After(testTimeout):
+		c.t.Fatalf("%v got no state delta", c.gs.GetUsername())
+	}
+	return gamelogic.StateDelta{}
+}
+
+func (c *testClient) move() gamelogic.ArmyMove {
+	c.t.Helper()
+	select {
+	case move := <-c.moves:
+		return move
+	case <-time.After(testTimeout):
+		c.t.Fatalf("%v saw no move", c.gs.GetUsername())
+	}
+	return gamelogic.ArmyMove{}
+}
+
+// newTestServer starts a match and consumes the game's commands like the server does
+func newTestServer(t *testing.T) (*pubsub.MemoryBroker, routing.Game, *gamelogic.World, routing.MatchState) {
+	t.Helper()
+	conn := pubsub.NewMemoryBroker()
+	t.Cleanup(func() { conn.Close() })
+	game, err := routing.NewGame("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pubsub.ApplyTopology(conn, routing.PerilTopology().Merge(game.Topology()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	world := gamelogic.NewWorld()
+	ms, err := world.StartMatch(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := game.CommandsBinding()
+	commandSub, err := pubsub.SubscribeDelivery(conn, commands.Exchange, commands.Queue, commands.Key, pubsub.DurableQueue, handlerCommands(game, world, conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { commandSub.Close(context.Background()) })
+	return conn, game, world, ms
+}
+
+func TestGameFlow(t *testing.T) {
+	conn, game, world, ms := newTestServer(t)
+
+	alice := newTestClient(t, conn, game, "alice", ms)
+	bob := newTestClient(t, conn, game, "bob", ms)
+
+	alice.send(alice.gs.CommandSpawn([]string{"spawn", "europe", "infantry"}))
+	delta := alice.delta()
+	if len(delta.Spawned) != 1 || delta.Spawned[0].ID != 1 {
+		t.Fatalf("alice spawned %v, want unit 1", delta.Spawned)
+	}
+	if alice.gs.Resources() != gamelogic.StartingResources-1 {
+		t.Errorf("alice has %v resources, want %v", alice.gs.Resources(), gamelogic.StartingResources-1)
+	}
+
+	bob.send(bob.gs.CommandSpawn([]string{"spawn", "asia", "artillery"}))
+	delta = bob.delta()
+	if len(delta.Spawned) != 1 || delta.Spawned[0].Location != "asia" {
+		t.Fatalf("bob spawned %v, want a unit in asia", delta.Spawned)
+	}
+
+	// a command on someone else's key is dead-lettered and changes nothing
+	spoofed := gamelogic.CommandRequest{Kind: gamelogic.CommandKindSpawn, Username: "bob", Location: "asia", Rank: gamelogic.RankInfantry}
+	alice.send(spoofed, nil)
+	deadline := time.Now().Add(testTimeout)
+	for conn.QueueLength(routing.QueuePerilDLQ) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the spoofed command was not dead-lettered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p, _ := world.Player("bob"); len(p.Units) != 1 {
+		t.Errorf("bob has %v units after the spoofed command, want 1", len(p.Units))
+	}
+
+	// alice's infantry walks into bob's artillery and loses
+	alice.send(alice.gs.CommandMove([]string{"move", "asia", "1"}))
+	delta = alice.delta()
+	if len(delta.Moved) != 1 || delta.Moved[0].Location != "asia" {
+		t.Fatalf("alice moved %v, want unit 1 in asia", delta.Moved)
+	}
+	seen := bob.move()
+	if seen.ToLocation != "asia" || seen.Player.Username != "alice" {
+		t.Errorf("bob saw %v move to %v, want alice to asia", seen.Player.Username, seen.ToLocation)
+	}
+
+	for _, c := range []*testClient{alice, bob} {
+		delta := c.delta()
+		if delta.War == nil {
+			t.Fatalf("%v got %+v, want the war", c.gs.GetUsername(), delta)
+		}
+	}
+	if units := alice.gs.GetPlayerSnap().Units; len(units) != 0 {
+		t.Errorf("alice's client still has %v units", len(units))
+	}
+	if units := bob.gs.GetPlayerSnap().Units; len(units) != 1 {
+		t.Errorf("bob's client has %v units, want 1", len(units))
+	}
+
+	final, over := world.CheckVictory(time.Now())
+	if !over || final.Winner != "bob" {
+		t.Errorf("match over = %v with winner %q, want bob", over, final.Winner)
+	}
+}
+
+// a client can be changed to skip its own checks, so the server has to refuse
+// these commands itself and leave the world as it was
+func TestHandlerCommandsRejects(t *testing.T) {
+	conn, game, world, ms := newTestServer(t)
+	alice := newTestClient(t, conn, game, "alice", ms)
+	bob := newTestClient(t, conn, game, "bob", ms)
+
+	// alice spends everything on two artillery, bob has units alice does not
+	for i := 0; i < 2; i++ {
+		alice.send(alice.gs.CommandSpawn([]string{"spawn", "europe", "artillery"}))
+		alice.delta()
+	}
+	for i := 0; i < 3; i++ {
+		bob.send(bob.gs.CommandSpawn([]string{"spawn", "asia", "infantry"}))
+		bob.delta()
+	}
+
+	tests := []struct {
+		name     string
+		cmd      gamelogic.CommandRequest
+		rejected string
+	}{
+		{
+			name:     "spawn without the resources",
+			cmd:      gamelogic.CommandRequest{Kind: gamelogic.CommandKindSpawn, Username: "alice", Location: "europe", Rank: gamelogic.RankInfantry},
+			rejected: "not enough resources",
+		},
+		{
+			name:     "move another player's unit",
+			cmd:      gamelogic.CommandRequest{Kind: gamelogic.CommandKindMove, Username: "alice", Location: "europe", UnitIDs: []int{3}},
+			rejected: "unit with ID 3 not found",
+		},
+		{
+			name:     "move to an unknown location",
+			cmd:      gamelogic.CommandRequest{Kind: gamelogic.CommandKindMove, Username: "alice", Location: "atlantis", UnitIDs: []int{1}},
+			rejected: "atlantis is not a valid location",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beforeAlice, _ := world.Player("alice")
+			beforeBob, _ := world.Player("bob")
+
+			alice.send(tt.cmd, nil)
+			delta := alice.delta()
+			if !strings.Contains(delta.Rejected, tt.rejected) {
+				t.Fatalf("alice got %+v, want a refusal with %q", delta, tt.rejected)
+			}
+			if got := world.Resources("alice"); got != 0 {
+				t.Errorf("alice has %v resources, want 0", got)
+			}
+			afterAlice, _ := world.Player("alice")
+			if !reflect.DeepEqual(afterAlice.Units, beforeAlice.Units) {
+				t.Errorf("alice's units changed from %v to %v", beforeAlice.Units, afterAlice.Units)
+			}
+			afterBob, _ := world.Player("bob")
+			if !reflect.DeepEqual(afterBob.Units, beforeBob.Units) {
+				t.Errorf("bob's units changed from %v to %v", beforeBob.Units, afterBob.Units)
+			}
+			select {
+			case move := <-bob.moves:
+				t.Errorf("bob saw a move to %v", move.ToLocation)
+			default:
+			}
+		})
+	}
+}
+
+func TestHandlerSync(t *testing.T) {
+	conn, game, world, ms := newTestServer(t)
+	sync := game.SyncBinding()
+	syncSub, err := pubsub.Serve(conn, sync.Exchange, sync.Queue, sync.Key, pubsub.DurableQueue, handlerSync(world))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncSub.Close(context.Background())
+
+	alice := newTestClient(t, conn, game, "alice", ms)
+	alice.send(alice.gs.CommandSpawn([]string{"spawn", "europe", "infantry"}))
+	alice.delta()
+
+	syncer, err := pubsub.NewRequester(conn, routing.ExchangePerilDirect, game.SyncReplyQueue("alice").Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	snap, err := pubsub.Request[gamelogic.SyncRequest, gamelogic.Snapshot](ctx, syncer, game.SyncKey(), pubsub.ContentTypeJSON, gamelogic.SyncRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Player.Username != "alice" || len(snap.Player.Units) != 1 || snap.Resources != world.Resources("alice") {
+		t.Errorf("got %+v, want alice with one unit and %v resources", snap, world.Resources("alice"))
+	}
+
+	_, err = pubsub.Request[gamelogic.SyncRequest, gamelogic.Snapshot](ctx, syncer, game.SyncKey(), pubsub.ContentTypeJSON, gamelogic.SyncRequest{})
+	if err == nil {
+		t.Error("the server answered a sync request without a username")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// the management API is the only way to list bindings, AMQP itself can only declare them
+
+type brokerExchange struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Durable bool   `json:"durable"`
+}
+
+type brokerQueue struct {
+	Name      string                 `json:"name"`
+	Durable   bool                   `json:"durable"`
+	Exclusive bool                   `json:"exclusive"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+type brokerBinding struct {
+	Source          string `json:"source"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"`
+	RoutingKey      string `json:"routing_key"`
+}
+
+type brokerState struct {
+	exchanges []brokerExchange
+	queues    []brokerQueue
+	bindings  []brokerBinding
+}
+
+type managementClient struct {
+	baseURL  string
+	vhost    string
+	username string
+	password string
+	http     *http.Client
+}
+
+func newManagementClient(baseURL, vhost, username, password string) *managementClient {
+	return &managementClient{
+		baseURL:  baseURL,
+		vhost:    vhost,
+		username: username,
+		password: password,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *managementClient) state() (brokerState, error) {
+	state := brokerState{}
+
+	err := c.get("exchanges", &state.exchanges)
+	if err != nil {
+		return brokerState{}, err
+	}
+	err = c.get("queues", &state.queues)
+	if err != nil {
+		return brokerState{}, err
+	}
+	err = c.get("bindings", &state.bindings)
+	if err != nil {
+		return brokerState{}, err
+	}
+	return state, nil
+}
+
+func (c *managementClient) get(resource string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/"+resource+"/"+url.PathEscape(c.vhost), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("management API returned %v for %v", resp.Status, resource)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,104 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// RankStats is everything the game needs to know about one unit rank
+type RankStats struct {
+	Rank  UnitRank `json:"rank"`
+	Power int      `json:"power"`
+	Cost  int      `json:"cost"`
+	// Movement is the most an edge may cost for the unit to cross it in one move
+	Movement int `json:"movement"`
+	// Modifiers are added to the power of each unit fighting in the location
+	Modifiers map[Location]int `json:"modifiers,omitempty"`
+}
+
+// Catalog is the unit ranks a game is played with. Server and clients must use the same one.
+type Catalog struct {
+	ranks map[UnitRank]RankStats
+}
+
+// CatalogFile is the JSON format of a catalog
+type CatalogFile struct {
+	Ranks []RankStats `json:"ranks"`
+}
+
+func DefaultCatalog() *Catalog {
+	c, err := NewCatalog(CatalogFile{
+		Ranks: []RankStats{
+			{Rank: RankInfantry, Power: 1, Cost: 1, Movement: 2},
+			{Rank: RankCavalry, Power: 5, Cost: 3, Movement: 3},
+			{Rank: RankArtillery, Power: 10, Cost: 5, Movement: 2},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func NewCatalog(file CatalogFile) (*Catalog, error) {
+	c := &Catalog{ranks: map[UnitRank]RankStats{}}
+	for _, stats := range file.Ranks {
+		if stats.Rank == "" {
+			return nil, fmt.Errorf("unit catalog has a rank without a name")
+		}
+		if _, ok := c.ranks[stats.Rank]; ok {
+			return nil, fmt.Errorf("unit catalog has %v twice", stats.Rank)
+		}
+		if stats.Power < 0 || stats.Cost < 0 || stats.Movement < 1 {
+			return nil, fmt.Errorf("unit catalog has invalid stats for %v", stats.Rank)
+		}
+		c.ranks[stats.Rank] = stats
+	}
+	if len(c.ranks) == 0 {
+		return nil, fmt.Errorf("unit catalog has no ranks")
+	}
+	return c, nil
+}
+
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read unit catalog: %v", err)
+	}
+	file := CatalogFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode unit catalog %v: %v", path, err)
+	}
+	return NewCatalog(file)
+}
+
+func (c *Catalog) Stats(rank UnitRank) (RankStats, bool) {
+	stats, ok := c.ranks[rank]
+	return stats, ok
+}
+
+// Ranks are sorted by name
+func (c *Catalog) Ranks() []UnitRank {
+	ranks := []UnitRank{}
+	for rank := range c.ranks {
+		ranks = append(ranks, rank)
+	}
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i] < ranks[j] })
+	return ranks
+}
+
+// Power of the units fighting in the location, a rank that is not in the catalog has none
+func (c *Catalog) Power(units []Unit, location Location) int {
+	power := 0
+	for _, unit := range units {
+		stats, ok := c.ranks[unit.Rank]
+		if !ok {
+			continue
+		}
+		power += stats.Power + stats.Modifiers[location]
+	}
+	return power
+}
@@ -0,0 +1,108 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCatalogStats(t *testing.T) {
+	tests := []struct {
+		rank     UnitRank
+		ok       bool
+		power    int
+		cost     int
+		movement int
+	}{
+		{RankInfantry, true, 1, 1, 2},
+		{RankCavalry, true, 5, 3, 3},
+		{RankArtillery, true, 10, 5, 2},
+		{"dragon", false, 0, 0, 0},
+	}
+
+	c := DefaultCatalog()
+	for _, tt := range tests {
+		t.Run(string(tt.rank), func(t *testing.T) {
+			stats, ok := c.Stats(tt.rank)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if stats.Power != tt.power || stats.Cost != tt.cost || stats.Movement != tt.movement {
+				t.Errorf("stats = %+v, want power %v, cost %v, movement %v", stats, tt.power, tt.cost, tt.movement)
+			}
+		})
+	}
+
+	if ranks := c.Ranks(); !slices.Equal(ranks, []UnitRank{RankArtillery, RankCavalry, RankInfantry}) {
+		t.Errorf("ranks = %v, want them sorted by name", ranks)
+	}
+}
+
+func TestCatalogPower(t *testing.T) {
+	c, err := LoadCatalog(filepath.Join("..", "..", "units", "fortified.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ranks    []UnitRank
+		location Location
+		power    int
+	}{
+		{"no units", nil, "europe", 0},
+		{"without modifiers", []UnitRank{RankCavalry, RankArtillery}, "europe", 15},
+		{"modifier per unit", []UnitRank{RankInfantry, RankInfantry}, "europe", 4},
+		{"negative modifiers", []UnitRank{RankCavalry, RankArtillery}, "antarctica", 7},
+		{"a rank only this catalog has", []UnitRank{"engineers"}, "antarctica", 6},
+		{"unknown ranks have no power", []UnitRank{"dragon", RankInfantry}, "americas", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units := []Unit{}
+			for i, rank := range tt.ranks {
+				units = append(units, Unit{ID: i + 1, Rank: rank, Location: tt.location})
+			}
+			if got := c.Power(units, tt.location); got != tt.power {
+				t.Errorf("power = %v, want %v", got, tt.power)
+			}
+		})
+	}
+}
+
+func TestNewCatalogRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file CatalogFile
+	}{
+		{"no ranks", CatalogFile{}},
+		{"rank without a name", CatalogFile{Ranks: []RankStats{{Power: 1, Movement: 1}}}},
+		{"rank twice", CatalogFile{Ranks: []RankStats{{Rank: "a", Movement: 1}, {Rank: "a", Movement: 1}}}},
+		{"negative power", CatalogFile{Ranks: []RankStats{{Rank: "a", Power: -1, Movement: 1}}}},
+		{"negative cost", CatalogFile{Ranks: []RankStats{{Rank: "a", Cost: -1, Movement: 1}}}},
+		{"no movement", CatalogFile{Ranks: []RankStats{{Rank: "a", Power: 1}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCatalog(tt.file)
+			if err == nil {
+				t.Error("the catalog was accepted")
+			}
+		})
+	}
+}
+
+func TestLoadCatalogRefusesBadJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "units.json")
+	err := os.WriteFile(path, []byte(`{"ranks": [`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadCatalog(path)
+	if err == nil {
+		t.Error("loaded a catalog that is not JSON")
+	}
+}
@@ -0,0 +1,47 @@
+package gamelogic
+
+import "fmt"
+
+// HandleDelta applies a change the server made to this player's state
+func (gs *GameState) HandleDelta(delta StateDelta) {
+	if delta.Username != gs.GetUsername() {
+		return
+	}
+
+	if delta.Rejected != "" {
+		fmt.Printf("The server refused your command: %s\n", delta.Rejected)
+	}
+
+	if delta.Queued != nil {
+		gs.queueOrder(*delta.Queued)
+		fmt.Printf("Your %s order is queued for turn %v\n", delta.Queued.Kind, delta.Queued.Turn)
+	}
+
+	if delta.Resources != nil {
+		gs.setResources(*delta.Resources)
+		gs.record(Event{Kind: EventResourcesChanged, Username: delta.Username, Resources: delta.Resources})
+	}
+
+	for _, unit := range delta.Spawned {
+		err := gs.addUnit(unit)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		gs.record(Event{Kind: EventUnitSpawned, Username: delta.Username, Location: unit.Location, Units: []Unit{unit}})
+		fmt.Printf("Spawned a(n) %s in %s with id %v\n", unit.Rank, unit.Location, unit.ID)
+	}
+
+	if len(delta.Moved) > 0 {
+		for _, unit := range delta.Moved {
+			gs.UpdateUnit(unit)
+		}
+		gs.record(Event{Kind: EventUnitsMoved, Username: delta.Username, Location: delta.Moved[0].Location, Units: delta.Moved})
+		fmt.Printf("Moved %v units to %s\n", len(delta.Moved), delta.Moved[0].Location)
+	}
+
+	if delta.War != nil {
+		gs.record(Event{Kind: EventWarFought, Username: delta.War.Attacker.Username, War: delta.War})
+		gs.HandleWar(*delta.War)
+	}
+}
@@ -0,0 +1,352 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DiplomacyKind string
+
+const (
+	DiplomacyProposeAlliance DiplomacyKind = "propose_alliance"
+	DiplomacyAcceptAlliance  DiplomacyKind = "accept_alliance"
+	// DiplomacyBreakAlliance ends the alliance or the truce, the players are at war again
+	DiplomacyBreakAlliance DiplomacyKind = "break_alliance"
+	DiplomacyProposeTruce  DiplomacyKind = "propose_truce"
+	DiplomacyAcceptTruce   DiplomacyKind = "accept_truce"
+)
+
+// DiplomacyMessage is sent on diplomacy.<from>. Until is only set on truce proposals.
+type DiplomacyMessage struct {
+	Kind   DiplomacyKind
+	From   string
+	To     string
+	Until  time.Time
+	SentAt time.Time
+}
+
+type Relation string
+
+const (
+	RelationWar      Relation = "war"
+	RelationTruce    Relation = "truce"
+	RelationAlliance Relation = "alliance"
+)
+
+type Treaty struct {
+	Relation Relation
+	// Until is when a truce ends, alliances last until they are broken
+	Until time.Time
+}
+
+// Diplomacy is every treaty and open proposal between players. Clients and the server
+// apply the same messages in the same order, so they agree on who is at peace.
+// It is not safe for concurrent use, GameState and World keep it behind their locks.
+type Diplomacy struct {
+	treaties  map[string]Treaty
+	proposals map[string]DiplomacyMessage
+}
+
+func NewDiplomacy() *Diplomacy {
+	return &Diplomacy{
+		treaties:  map[string]Treaty{},
+		proposals: map[string]DiplomacyMessage{},
+	}
+}
+
+// pairKey is the same whichever player comes first
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// proposalKey is one open proposal per kind of treaty from one player to another
+func proposalKey(from, to string, relation Relation) string {
+	return from + ">" + to + ":" + string(relation)
+}
+
+// Apply changes the treaties and reports whether the players' relation changed,
+// proposals never change it
+func (d *Diplomacy) Apply(msg DiplomacyMessage, now time.Time) (bool, error) {
+	if msg.From == "" || msg.To == "" || msg.From == msg.To {
+		return false, fmt.Errorf("error: %v can not make a treaty with %v", msg.From, msg.To)
+	}
+	pair := pairKey(msg.From, msg.To)
+
+	switch msg.Kind {
+	case DiplomacyProposeAlliance:
+		d.proposals[proposalKey(msg.From, msg.To, RelationAlliance)] = msg
+		return false, nil
+
+	case DiplomacyProposeTruce:
+		if !msg.Until.After(now) {
+			return false, errors.New("error: a truce must end in the future")
+		}
+		d.proposals[proposalKey(msg.From, msg.To, RelationTruce)] = msg
+		return false, nil
+
+	case DiplomacyAcceptAlliance:
+		key := proposalKey(msg.To, msg.From, RelationAlliance)
+		if _, ok := d.proposals[key]; !ok {
+			return false, fmt.Errorf("error: %v did not propose an alliance to %v", msg.To, msg.From)
+		}
+		delete(d.proposals, key)
+		d.treaties[pair] = Treaty{Relation: RelationAlliance}
+		return true, nil
+
+	case DiplomacyAcceptTruce:
+		key := proposalKey(msg.To, msg.From, RelationTruce)
+		proposal, ok := d.proposals[key]
+		if !ok {
+			return false, fmt.Errorf("error: %v did not propose a truce to %v", msg.To, msg.From)
+		}
+		delete(d.proposals, key)
+		if !proposal.Until.After(now) {
+			return false, fmt.Errorf("error: the truce %v proposed has already ended", msg.To)
+		}
+		// a truce does not cut an alliance short
+		if d.relation(pair, now) == RelationAlliance {
+			return false, nil
+		}
+		d.treaties[pair] = Treaty{Relation: RelationTruce, Until: proposal.Until}
+		return true, nil
+
+	case DiplomacyBreakAlliance:
+		if d.relation(pair, now) == RelationWar {
+			return false, fmt.Errorf("error: %v and %v are already at war", msg.From, msg.To)
+		}
+		delete(d.treaties, pair)
+		return true, nil
+	}
+	return false, fmt.Errorf("error: unknown diplomacy message %q", msg.Kind)
+}
+
+func (d *Diplomacy) Relation(a, b string, now time.Time) Relation {
+	return d.relation(pairKey(a, b), now)
+}
+
+func (d *Diplomacy) relation(pair string, now time.Time) Relation {
+	treaty, ok := d.treaties[pair]
+	if !ok {
+		return RelationWar
+	}
+	if treaty.Relation == RelationTruce && !treaty.Until.After(now) {
+		return RelationWar
+	}
+	return treaty.Relation
+}
+
+// AtPeace players do not fight when their units meet
+func (d *Diplomacy) AtPeace(a, b string, now time.Time) bool {
+	return d.Relation(a, b, now) != RelationWar
+}
+
+// Treaties are the player's treaties that are still in force, by the other player's name
+func (d *Diplomacy) Treaties(username string, now time.Time) map[string]Treaty {
+	treaties := map[string]Treaty{}
+	for pair, treaty := range d.treaties {
+		if d.relation(pair, now) == RelationWar {
+			continue
+		}
+		a, b, _ := strings.Cut(pair, "|")
+		if a == username {
+			treaties[b] = treaty
+		} else if b == username {
+			treaties[a] = treaty
+		}
+	}
+	return treaties
+}
+
+// DiplomacySnapshot is the treaties in force and the open proposals, as kept in snapshots
+type DiplomacySnapshot struct {
+	Treaties  []SavedTreaty
+	Proposals []DiplomacyMessage
+}
+
+type SavedTreaty struct {
+	Players [2]string
+	Treaty
+}
+
+// Snapshot is sorted so the same treaties are always saved the same way
+func (d *Diplomacy) Snapshot(now time.Time) DiplomacySnapshot {
+	snap := DiplomacySnapshot{Treaties: []SavedTreaty{}, Proposals: []DiplomacyMessage{}}
+	for pair, treaty := range d.treaties {
+		if d.relation(pair, now) == RelationWar {
+			continue
+		}
+		a, b, _ := strings.Cut(pair, "|")
+		snap.Treaties = append(snap.Treaties, SavedTreaty{Players: [2]string{a, b}, Treaty: treaty})
+	}
+	sort.Slice(snap.Treaties, func(i, j int) bool {
+		return pairKey(snap.Treaties[i].Players[0], snap.Treaties[i].Players[1]) < pairKey(snap.Treaties[j].Players[0], snap.Treaties[j].Players[1])
+	})
+	keys := []string{}
+	for key := range d.proposals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		snap.Proposals = append(snap.Proposals, d.proposals[key])
+	}
+	return snap
+}
+
+// Involving keeps the treaties and proposals the player takes part in
+func (snap DiplomacySnapshot) Involving(username string) DiplomacySnapshot {
+	involving := DiplomacySnapshot{Treaties: []SavedTreaty{}, Proposals: []DiplomacyMessage{}}
+	for _, saved := range snap.Treaties {
+		if saved.Players[0] == username || saved.Players[1] == username {
+			involving.Treaties = append(involving.Treaties, saved)
+		}
+	}
+	for _, msg := range snap.Proposals {
+		if msg.From == username || msg.To == username {
+			involving.Proposals = append(involving.Proposals, msg)
+		}
+	}
+	return involving
+}
+
+func RestoreDiplomacy(snap DiplomacySnapshot) *Diplomacy {
+	d := NewDiplomacy()
+	for _, saved := range snap.Treaties {
+		d.treaties[pairKey(saved.Players[0], saved.Players[1])] = saved.Treaty
+	}
+	for _, msg := range snap.Proposals {
+		relation := RelationAlliance
+		if msg.Kind == DiplomacyProposeTruce {
+			relation = RelationTruce
+		}
+		d.proposals[proposalKey(msg.From, msg.To, relation)] = msg
+	}
+	return d
+}
+
+// HandleDiplomacy applies the messages the player takes part in, everyone else's are ignored
+func (gs *GameState) HandleDiplomacy(msg DiplomacyMessage) {
+	username := gs.GetUsername()
+	if msg.From != username && msg.To != username {
+		return
+	}
+	gs.mu.Lock()
+	changed, err := gs.diplomacy.Apply(msg, time.Now())
+	gs.mu.Unlock()
+	if err != nil {
+		if msg.From == username {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	other := msg.From
+	if other == username {
+		other = msg.To
+	}
+	switch msg.Kind {
+	case DiplomacyProposeAlliance:
+		if msg.To == username {
+			fmt.Printf("%s proposes an alliance, answer with: ally accept %s\n", other, other)
+		}
+	case DiplomacyProposeTruce:
+		if msg.To == username {
+			fmt.Printf("%s proposes a truce until %s, answer with: truce accept %s\n", other, msg.Until.Format(time.Kitchen), other)
+		}
+	case DiplomacyAcceptAlliance:
+		fmt.Printf("You are allied with %s\n", other)
+	case DiplomacyAcceptTruce:
+		if changed {
+			fmt.Printf("You are in a truce with %s\n", other)
+		}
+	case DiplomacyBreakAlliance:
+		fmt.Printf("You are at war with %s again\n", other)
+	}
+}
+
+// Relation is how the player stands with the other player right now
+func (gs *GameState) Relation(username string) Relation {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.diplomacy.Relation(gs.Player.Username, username, time.Now())
+}
+
+// CommandAlly builds the message, it only counts once it comes back on diplomacy.*
+func (gs *GameState) CommandAlly(words []string) (DiplomacyMessage, error) {
+	if len(words) < 3 {
+		return DiplomacyMessage{}, errors.New("usage: ally propose|accept|break <username>")
+	}
+	kinds := map[string]DiplomacyKind{
+		"propose": DiplomacyProposeAlliance,
+		"accept":  DiplomacyAcceptAlliance,
+		"break":   DiplomacyBreakAlliance,
+	}
+	kind, ok := kinds[words[1]]
+	if !ok {
+		return DiplomacyMessage{}, errors.New("usage: ally propose|accept|break <username>")
+	}
+	return gs.diplomacyMessage(kind, words[2], time.Time{})
+}
+
+func (gs *GameState) CommandTruce(words []string) (DiplomacyMessage, error) {
+	if len(words) >= 3 && words[1] == "accept" {
+		return gs.diplomacyMessage(DiplomacyAcceptTruce, words[2], time.Time{})
+	}
+	if len(words) < 4 || words[1] != "propose" {
+		return DiplomacyMessage{}, errors.New("usage: truce propose <username> <seconds> | truce accept <username>")
+	}
+	seconds, err := strconv.Atoi(words[3])
+	if err != nil || seconds < 1 {
+		return DiplomacyMessage{}, fmt.Errorf("error: %s is not a valid number of seconds", words[3])
+	}
+	return gs.diplomacyMessage(DiplomacyProposeTruce, words[2], time.Now().Add(time.Duration(seconds)*time.Second))
+}
+
+func (gs *GameState) diplomacyMessage(kind DiplomacyKind, to string, until time.Time) (DiplomacyMessage, error) {
+	if to == gs.GetUsername() {
+		return DiplomacyMessage{}, errors.New("error: you can not make a treaty with yourself")
+	}
+	return DiplomacyMessage{
+		Kind:   kind,
+		From:   gs.GetUsername(),
+		To:     to,
+		Until:  until,
+		SentAt: time.Now(),
+	}, nil
+}
+
+func (gs *GameState) CommandDiplomacy() {
+	gs.mu.RLock()
+	treaties := gs.diplomacy.Treaties(gs.Player.Username, time.Now())
+	gs.mu.RUnlock()
+	if len(treaties) == 0 {
+		fmt.Println("You are at war with everyone.")
+		return
+	}
+	names := []string{}
+	for name := range treaties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		treaty := treaties[name]
+		if treaty.Relation == RelationTruce {
+			fmt.Printf("* %v: truce until %v\n", name, treaty.Until.Format(time.Kitchen))
+			continue
+		}
+		fmt.Printf("* %v: %v\n", name, treaty.Relation)
+	}
+}
+
+// ApplyDiplomacy keeps the world's treaties in step with the players', allies never fight
+func (w *World) ApplyDiplomacy(msg DiplomacyMessage) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.diplomacy.Apply(msg, time.Now())
+}
@@ -0,0 +1,217 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiplomacyApply(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	propose := func(from, to string) DiplomacyMessage {
+		return DiplomacyMessage{Kind: DiplomacyProposeAlliance, From: from, To: to}
+	}
+	accept := func(from, to string) DiplomacyMessage {
+		return DiplomacyMessage{Kind: DiplomacyAcceptAlliance, From: from, To: to}
+	}
+	proposeTruce := func(from, to string, until time.Time) DiplomacyMessage {
+		return DiplomacyMessage{Kind: DiplomacyProposeTruce, From: from, To: to, Until: until}
+	}
+	acceptTruce := func(from, to string) DiplomacyMessage {
+		return DiplomacyMessage{Kind: DiplomacyAcceptTruce, From: from, To: to}
+	}
+	breakTreaty := func(from, to string) DiplomacyMessage {
+		return DiplomacyMessage{Kind: DiplomacyBreakAlliance, From: from, To: to}
+	}
+
+	tests := []struct {
+		name string
+		// before are applied first and must all be accepted
+		before []DiplomacyMessage
+		msg    DiplomacyMessage
+		// at is when msg is applied and the relation is checked, now when it is zero
+		at       time.Time
+		changed  bool
+		wantErr  bool
+		relation Relation
+	}{
+		{
+			name:     "propose",
+			msg:      propose("alice", "bob"),
+			relation: RelationWar,
+		},
+		{
+			name:     "accept",
+			before:   []DiplomacyMessage{propose("alice", "bob")},
+			msg:      accept("bob", "alice"),
+			changed:  true,
+			relation: RelationAlliance,
+		},
+		{
+			name:     "accept your own proposal",
+			before:   []DiplomacyMessage{propose("alice", "bob")},
+			msg:      accept("alice", "bob"),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "accept a proposal that was never made",
+			msg:      accept("bob", "alice"),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "accept a proposal twice",
+			before:   []DiplomacyMessage{propose("alice", "bob"), accept("bob", "alice")},
+			msg:      accept("bob", "alice"),
+			wantErr:  true,
+			relation: RelationAlliance,
+		},
+		{
+			name:     "accept an alliance as a truce",
+			before:   []DiplomacyMessage{propose("alice", "bob")},
+			msg:      acceptTruce("bob", "alice"),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "propose a truce that has already ended",
+			msg:      proposeTruce("alice", "bob", now),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "accept a truce",
+			before:   []DiplomacyMessage{proposeTruce("alice", "bob", later)},
+			msg:      acceptTruce("bob", "alice"),
+			changed:  true,
+			relation: RelationTruce,
+		},
+		{
+			name:     "accept a truce after it would have ended",
+			before:   []DiplomacyMessage{proposeTruce("alice", "bob", later)},
+			msg:      acceptTruce("bob", "alice"),
+			at:       later,
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "a truce does not cut an alliance short",
+			before:   []DiplomacyMessage{propose("alice", "bob"), accept("bob", "alice"), proposeTruce("alice", "bob", later)},
+			msg:      acceptTruce("bob", "alice"),
+			relation: RelationAlliance,
+		},
+		{
+			name:     "the truce expires",
+			before:   []DiplomacyMessage{proposeTruce("alice", "bob", later), acceptTruce("bob", "alice")},
+			msg:      propose("carol", "dave"),
+			at:       later,
+			relation: RelationWar,
+		},
+		{
+			name:     "break an alliance",
+			before:   []DiplomacyMessage{propose("alice", "bob"), accept("bob", "alice")},
+			msg:      breakTreaty("bob", "alice"),
+			changed:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "break a truce",
+			before:   []DiplomacyMessage{proposeTruce("alice", "bob", later), acceptTruce("bob", "alice")},
+			msg:      breakTreaty("alice", "bob"),
+			changed:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "break a treaty that does not exist",
+			msg:      breakTreaty("alice", "bob"),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "break an expired truce",
+			before:   []DiplomacyMessage{proposeTruce("alice", "bob", later), acceptTruce("bob", "alice")},
+			msg:      breakTreaty("alice", "bob"),
+			at:       later,
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "propose to yourself",
+			msg:      propose("alice", "alice"),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "propose without a name",
+			msg:      propose("alice", ""),
+			wantErr:  true,
+			relation: RelationWar,
+		},
+		{
+			name:     "unknown kind",
+			msg:      DiplomacyMessage{Kind: "declare_war", From: "alice", To: "bob"},
+			wantErr:  true,
+			relation: RelationWar,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDiplomacy()
+			for _, msg := range tt.before {
+				_, err := d.Apply(msg, now)
+				if err != nil {
+					t.Fatalf("%v was refused: %v", msg.Kind, err)
+				}
+			}
+			at := tt.at
+			if at.IsZero() {
+				at = now
+			}
+
+			changed, err := d.Apply(tt.msg, at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error %v", err, tt.wantErr)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if got := d.Relation("alice", "bob", at); got != tt.relation {
+				t.Errorf("relation = %v, want %v", got, tt.relation)
+			}
+			// both players see the same relation
+			if d.Relation("alice", "bob", at) != d.Relation("bob", "alice", at) {
+				t.Error("alice and bob do not agree on their relation")
+			}
+		})
+	}
+}
+
+func TestDiplomacyTreaties(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDiplomacy()
+	for _, msg := range []DiplomacyMessage{
+		{Kind: DiplomacyProposeAlliance, From: "alice", To: "bob"},
+		{Kind: DiplomacyAcceptAlliance, From: "bob", To: "alice"},
+		{Kind: DiplomacyProposeTruce, From: "carol", To: "alice", Until: now.Add(time.Minute)},
+		{Kind: DiplomacyAcceptTruce, From: "alice", To: "carol"},
+	} {
+		_, err := d.Apply(msg, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	treaties := d.Treaties("alice", now)
+	if len(treaties) != 2 || treaties["bob"].Relation != RelationAlliance || treaties["carol"].Relation != RelationTruce {
+		t.Errorf("alice's treaties are %v, want an alliance with bob and a truce with carol", treaties)
+	}
+	if treaties := d.Treaties("alice", now.Add(time.Minute)); len(treaties) != 1 {
+		t.Errorf("alice's treaties are %v after the truce ended, want only the alliance", treaties)
+	}
+	if treaties := d.Treaties("dave", now); len(treaties) != 0 {
+		t.Errorf("dave's treaties are %v, want none", treaties)
+	}
+}
@@ -0,0 +1,94 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// StartingResources is what a player has when they first join the game
+const StartingResources = 10
+
+// Controller is the only player with units in the location, nobody controls a contested location
+func (w *World) controller(location Location) (string, bool) {
+	owner := ""
+	for _, name := range w.usernames() {
+		for _, unit := range w.players[name].Units {
+			if unit.Location != location {
+				continue
+			}
+			if owner != "" && owner != name {
+				return "", false
+			}
+			owner = name
+			break
+		}
+	}
+	return owner, owner != ""
+}
+
+// Tick pays every player the income of the locations they control and returns what each earned
+func (w *World) Tick() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.collectIncome()
+}
+
+// collectIncome is called with the lock held
+func (w *World) collectIncome() map[string]int {
+	earned := map[string]int{}
+	for _, location := range w.gameMap.Locations() {
+		owner, ok := w.controller(location)
+		if !ok {
+			continue
+		}
+		earned[owner] += w.gameMap.Income(location)
+	}
+
+	for _, name := range w.usernames() {
+		if earned[name] == 0 {
+			continue
+		}
+		w.resources[name] += earned[name]
+		total := w.resources[name]
+		w.record(Event{Kind: EventResourcesChanged, Username: name, Resources: &total})
+	}
+	return earned
+}
+
+// Resources is what the player has left to spend
+func (w *World) Resources(username string) int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.resourcesOf(username)
+}
+
+// resourcesOf is the starting resources for players who never spawned, it is called with the lock held
+func (w *World) resourcesOf(username string) int {
+	resources, ok := w.resources[username]
+	if !ok {
+		return StartingResources
+	}
+	return resources
+}
+
+// pay is called with the lock held
+func (w *World) pay(username string, cost int) error {
+	resources := w.resourcesOf(username)
+	if resources < cost {
+		return fmt.Errorf("error: not enough resources, you have %v and need %v", resources, cost)
+	}
+	w.resources[username] = resources - cost
+	return nil
+}
+
+// Resources is what the player had left when the server last told us
+func (gs *GameState) Resources() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.resources
+}
+
+func (gs *GameState) setResources(resources int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.resources = resources
+}
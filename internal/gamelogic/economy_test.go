@@ -0,0 +1,178 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestSpawnCost(t *testing.T) {
+	tests := []struct {
+		rank UnitRank
+		left int
+	}{
+		{RankInfantry, StartingResources - 1},
+		{RankCavalry, StartingResources - 3},
+		{RankArtillery, StartingResources - 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.rank), func(t *testing.T) {
+			w := newTestWorld(t)
+			mustSpawn(t, w, "alice", "europe", tt.rank)
+			if got := w.Resources("alice"); got != tt.left {
+				t.Errorf("alice has %v resources, want %v", got, tt.left)
+			}
+		})
+	}
+}
+
+func TestSpawnRefusedWithoutResources(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankArtillery)
+	mustSpawn(t, w, "alice", "europe", RankArtillery)
+
+	_, err := w.Spawn("alice", "europe", RankInfantry)
+	if err == nil || !strings.Contains(err.Error(), "not enough resources") {
+		t.Fatalf("err = %v, want not enough resources", err)
+	}
+	if got := w.Resources("alice"); got != 0 {
+		t.Errorf("alice has %v resources after the refused spawn, want 0", got)
+	}
+	if p, _ := w.Player("alice"); len(p.Units) != 2 {
+		t.Errorf("alice has %v units, want 2", len(p.Units))
+	}
+}
+
+func TestSpawnRefusedForNewPlayer(t *testing.T) {
+	catalog, err := NewCatalog(CatalogFile{Ranks: []RankStats{{Rank: "titan", Power: 100, Cost: StartingResources + 1, Movement: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWorld(t)
+	w.SetCatalog(catalog)
+
+	_, err = w.Spawn("alice", "europe", "titan")
+	if err == nil {
+		t.Fatal("alice spawned a unit without the resources for it")
+	}
+	if _, ok := w.Player("alice"); ok {
+		t.Error("the refused spawn made alice part of the world")
+	}
+	if got := w.Resources("alice"); got != StartingResources {
+		t.Errorf("alice has %v resources, want %v", got, StartingResources)
+	}
+}
+
+func TestIncome(t *testing.T) {
+	tests := []struct {
+		name   string
+		units  []CommandRequest
+		earned map[string]int
+	}{
+		{
+			name:   "one location",
+			units:  []CommandRequest{spawnOrder("alice", "americas", RankInfantry)},
+			earned: map[string]int{"alice": 1},
+		},
+		{
+			name:   "the map's income",
+			units:  []CommandRequest{spawnOrder("alice", "europe", RankInfantry), spawnOrder("alice", "americas", RankInfantry)},
+			earned: map[string]int{"alice": 3},
+		},
+		{
+			name:   "a location without income",
+			units:  []CommandRequest{spawnOrder("alice", "antarctica", RankInfantry)},
+			earned: map[string]int{},
+		},
+		{
+			name: "nobody controls a contested location",
+			units: []CommandRequest{
+				spawnOrder("alice", "asia", RankInfantry),
+				spawnOrder("bob", "asia", RankInfantry),
+				spawnOrder("bob", "africa", RankInfantry),
+			},
+			earned: map[string]int{"bob": 1},
+		},
+		{
+			name:   "several units in a location earn once",
+			units:  append(infantry("alice", "asia", 3), spawnOrder("bob", "americas", RankInfantry)),
+			earned: map[string]int{"alice": 2, "bob": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			for _, cmd := range tt.units {
+				mustSpawn(t, w, cmd.Username, cmd.Location, cmd.Rank)
+			}
+			before := map[string]int{}
+			for _, name := range w.Usernames() {
+				before[name] = w.Resources(name)
+			}
+
+			earned := w.Tick()
+			for _, name := range w.Usernames() {
+				if earned[name] != tt.earned[name] {
+					t.Errorf("%v earned %v, want %v", name, earned[name], tt.earned[name])
+				}
+				if got := w.Resources(name); got != before[name]+tt.earned[name] {
+					t.Errorf("%v has %v resources, want %v", name, got, before[name]+tt.earned[name])
+				}
+			}
+		})
+	}
+}
+
+func TestIncomeFromMapFile(t *testing.T) {
+	m, err := NewMap(MapFile{
+		Locations: []Location{"mine", "field"},
+		Edges:     []MapEdge{{From: "mine", To: "field", Cost: 1}},
+		Income:    map[Location]int{"mine": 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Income("mine") != 4 || m.Income("field") != defaultIncome {
+		t.Fatalf("income is %v and %v, want 4 and %v", m.Income("mine"), m.Income("field"), defaultIncome)
+	}
+
+	w := newTestWorld(t)
+	w.SetMap(m)
+	mustSpawn(t, w, "alice", "mine", RankInfantry)
+	mustSpawn(t, w, "alice", "field", RankInfantry)
+	if earned := w.Tick(); earned["alice"] != 5 {
+		t.Errorf("alice earned %v, want 5", earned["alice"])
+	}
+}
+
+func TestCommandSpawnChecksResources(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.HandleMatch(routing.MatchState{Phase: routing.MatchPhaseInProgress})
+	gs.setResources(4)
+
+	_, err := gs.CommandSpawn([]string{"spawn", "europe", RankArtillery})
+	if err == nil || !strings.Contains(err.Error(), "not enough resources") {
+		t.Errorf("err = %v, want not enough resources", err)
+	}
+	_, err = gs.CommandSpawn([]string{"spawn", "europe", RankCavalry})
+	if err != nil {
+		t.Errorf("an affordable spawn was refused: %v", err)
+	}
+}
+
+func TestTurnPaysIncome(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+	w.StartTurn()
+
+	result := w.ResolveTurn()
+	if result.Income["alice"] != 2 {
+		t.Errorf("alice earned %v at the end of the turn, want 2", result.Income["alice"])
+	}
+	if got := w.Resources("alice"); got != StartingResources-1+2 {
+		t.Errorf("alice has %v resources, want %v", got, StartingResources-1+2)
+	}
+}
@@ -0,0 +1,252 @@
+package gamelogic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventUnitSpawned EventKind = "unit_spawned"
+	EventUnitsMoved  EventKind = "units_moved"
+	EventWarFought   EventKind = "war_fought"
+	EventGamePaused  EventKind = "game_paused"
+	EventGameResumed EventKind = "game_resumed"
+	// EventResourcesChanged is a player earning from the locations they control,
+	// spawns carry the resources left after paying for the unit themselves
+	EventResourcesChanged EventKind = "resources_changed"
+	// EventStateLoaded replaces the units of every player in Units, it is
+	// recorded when a snapshot is loaded
+	EventStateLoaded EventKind = "state_loaded"
+)
+
+// Event is one change to the game. Applying every event in order rebuilds the game.
+type Event struct {
+	Seq      int64
+	Time     time.Time
+	Kind     EventKind
+	Username string            `json:",omitempty"`
+	Location Location          `json:",omitempty"`
+	Units    []Unit            `json:",omitempty"`
+	War      *RecognitionOfWar `json:",omitempty"`
+	// Resources is what the player has after the event, for events that change it
+	Resources *int `json:",omitempty"`
+}
+
+const eventsDir = "events"
+
+// EventLogPath is where the events of the server or of one client are kept
+func EventLogPath(name string) string {
+	return filepath.Join(eventsDir, name+".jsonl")
+}
+
+type EventLog interface {
+	Append(e Event) error
+}
+
+// FileEventLog appends events to a file as one JSON object per line
+type FileEventLog struct {
+	f   *os.File
+	seq int64
+	mu  *sync.Mutex
+}
+
+// OpenEventLog continues the sequence of the events that are already in the file.
+// Half an event left behind by a crash is cut off, so the next event starts on its own line.
+func OpenEventLog(path string) (*FileEventLog, error) {
+	events, valid, err := readEvents(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var seq int64
+	if len(events) > 0 {
+		seq = events[len(events)-1].Seq
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create event log directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open event log: %v", err)
+	}
+	err = truncateEvents(f, valid)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileEventLog{f: f, seq: seq, mu: &sync.Mutex{}}, nil
+}
+
+// truncateEvents drops everything after the last whole event and ends the file with a newline
+func truncateEvents(f *os.File, valid int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read event log: %v", err)
+	}
+	if info.Size() > valid {
+		err = f.Truncate(valid)
+		if err != nil {
+			return fmt.Errorf("could not cut the partial event off the event log: %v", err)
+		}
+	}
+	if valid == 0 {
+		return nil
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, valid-1)
+	if err != nil {
+		return fmt.Errorf("could not read event log: %v", err)
+	}
+	if last[0] != '\n' {
+		_, err = f.Write([]byte{'\n'})
+		if err != nil {
+			return fmt.Errorf("could not write to event log: %v", err)
+		}
+	}
+	return nil
+}
+
+func (l *FileEventLog) Append(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	e.Seq = l.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %v", err)
+	}
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write to event log: %v", err)
+	}
+	return nil
+}
+
+func (l *FileEventLog) Close() error {
+	return l.f.Close()
+}
+
+// ReadEvents reads every event in the file. A last line that can not be decoded is half an
+// event written when the game crashed and is skipped, a bad line before it is an error.
+func ReadEvents(path string) ([]Event, error) {
+	events, _, err := readEvents(path)
+	return events, err
+}
+
+// readEvents also returns where the last whole event ends in the file
+func readEvents(path string) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	events := []Event{}
+	reader := bufio.NewReader(f)
+	var offset, valid int64
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, fmt.Errorf("could not read %v: %v", path, err)
+		}
+		if len(b) == 0 {
+			break
+		}
+		offset += int64(len(b))
+
+		trimmed := bytes.TrimSpace(b)
+		if len(trimmed) == 0 {
+			valid = offset
+			continue
+		}
+		e := Event{}
+		decodeErr := json.Unmarshal(trimmed, &e)
+		if decodeErr != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				break
+			}
+			return nil, 0, fmt.Errorf("could not decode event on line %v of %v: %v", line, path, decodeErr)
+		}
+		events = append(events, e)
+		valid = offset
+	}
+	return events, valid, nil
+}
+
+// Apply changes the state the way the event did, without printing anything.
+// Events of other players only matter when they are wars this player fought.
+func (gs *GameState) Apply(e Event) {
+	username := gs.GetUsername()
+
+	switch e.Kind {
+	case EventUnitSpawned, EventUnitsMoved:
+		if e.Username != username {
+			return
+		}
+		for _, unit := range e.Units {
+			gs.UpdateUnit(unit)
+		}
+		if e.Resources != nil {
+			gs.setResources(*e.Resources)
+		}
+
+	case EventResourcesChanged:
+		if e.Username == username && e.Resources != nil {
+			gs.setResources(*e.Resources)
+		}
+
+	case EventWarFought:
+		if e.War == nil {
+			return
+		}
+		result, ok := ResolveWar(*e.War, gs.Catalog())
+		if ok {
+			gs.removeUnits(result.CasualtiesOf(username))
+		}
+
+	case EventGamePaused:
+		gs.pauseGame()
+
+	case EventGameResumed:
+		gs.resumeGame()
+
+	case EventStateLoaded:
+		units := map[int]Unit{}
+		for _, unit := range e.Units {
+			if unit.Owner == username {
+				units[unit.ID] = unit
+			}
+		}
+		gs.mu.Lock()
+		gs.Player.Units = units
+		gs.mu.Unlock()
+	}
+}
+
+// Replay rebuilds the state of username from the events up to and including the event with seq until.
+// The catalog must be the one the game was played with.
+func Replay(events []Event, username string, catalog *Catalog, until int64) *GameState {
+	gs := NewGameState(username)
+	gs.SetCatalog(catalog)
+	for _, e := range events {
+		if e.Seq > until {
+			break
+		}
+		gs.Apply(e)
+	}
+	return gs
+}
@@ -0,0 +1,182 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEvents is alice spawning two units, moving one into bob's artillery and losing it
+func testEvents() []Event {
+	infantry := Unit{ID: 1, Owner: "alice", Rank: RankInfantry, Location: "europe"}
+	cavalry := Unit{ID: 2, Owner: "alice", Rank: RankCavalry, Location: "europe"}
+	moved := infantry
+	moved.Location = "asia"
+	artillery := Unit{ID: 1, Owner: "bob", Rank: RankArtillery, Location: "asia"}
+
+	return []Event{
+		{Seq: 1, Kind: EventUnitSpawned, Username: "alice", Location: "europe", Units: []Unit{infantry}},
+		{Seq: 2, Kind: EventUnitSpawned, Username: "alice", Location: "europe", Units: []Unit{cavalry}},
+		{Seq: 3, Kind: EventUnitSpawned, Username: "bob", Location: "asia", Units: []Unit{artillery}},
+		{Seq: 4, Kind: EventUnitsMoved, Username: "alice", Location: "asia", Units: []Unit{moved}},
+		{Seq: 5, Kind: EventWarFought, Location: "asia", War: &RecognitionOfWar{
+			Attacker: Player{Username: "alice", Units: map[int]Unit{1: moved}},
+			Defender: Player{Username: "bob", Units: map[int]Unit{1: artillery}},
+		}},
+		{Seq: 6, Kind: EventGamePaused},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		until    int64
+		// want is where each of the player's units is
+		want   map[int]Location
+		paused bool
+	}{
+		{"nothing yet", "alice", 0, map[int]Location{}, false},
+		{"first spawn", "alice", 1, map[int]Location{1: "europe"}, false},
+		{"before the move", "alice", 3, map[int]Location{1: "europe", 2: "europe"}, false},
+		{"after the move", "alice", 4, map[int]Location{1: "asia", 2: "europe"}, false},
+		{"the lost war", "alice", 5, map[int]Location{2: "europe"}, false},
+		{"everything", "alice", 6, map[int]Location{2: "europe"}, true},
+		{"the other player", "bob", 6, map[int]Location{1: "asia"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := Replay(testEvents(), tt.username, DefaultCatalog(), tt.until)
+			units := gs.GetPlayerSnap().Units
+			if len(units) != len(tt.want) {
+				t.Fatalf("%v has units %v, want %v", tt.username, units, tt.want)
+			}
+			for id, location := range tt.want {
+				if units[id].Location != location {
+					t.Errorf("unit %v is in %v, want %v", id, units[id].Location, location)
+				}
+			}
+			if gs.isPaused() != tt.paused {
+				t.Errorf("paused = %v, want %v", gs.isPaused(), tt.paused)
+			}
+		})
+	}
+}
+
+func TestEventLogContinuesItsSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "server.jsonl")
+
+	l, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range testEvents()[:2] {
+		e.Seq = 0
+		err = l.Append(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	l, err = OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(Event{Kind: EventGamePaused})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	events, err := ReadEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("read %v events, want 3", len(events))
+	}
+	for i, e := range events {
+		if e.Seq != int64(i+1) {
+			t.Errorf("event %v has seq %v", i+1, e.Seq)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event %v has no time", i+1)
+		}
+	}
+}
+
+func TestReadEventsRefusesACorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.jsonl")
+	err := os.WriteFile(path, []byte("{\"Seq\":1,\"Kind\":\"game_paused\"}\nnot json\n{\"Seq\":3,\"Kind\":\"game_resumed\"}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadEvents(path)
+	if err == nil {
+		t.Error("read a log with a corrupt line")
+	}
+}
+
+func TestEventLogCutsOffATruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.jsonl")
+	// the server crashed while it wrote the third event
+	err := os.WriteFile(path, []byte("{\"Seq\":1,\"Kind\":\"game_paused\"}\n{\"Seq\":2,\"Kind\":\"game_resumed\"}\n{\"Seq\":3,\"Ki"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ReadEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("read %v events, want the 2 whole ones", len(events))
+	}
+
+	l, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(Event{Kind: EventGamePaused})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	events, err = ReadEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Seq != 3 || events[2].Kind != EventGamePaused {
+		t.Errorf("read %+v, want the new event in place of the half written one", events)
+	}
+}
+
+func TestEventLogEndsAnUnterminatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.jsonl")
+	// the last event is whole but its newline never made it to disk
+	err := os.WriteFile(path, []byte("{\"Seq\":1,\"Kind\":\"game_paused\"}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(Event{Kind: EventGameResumed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	events, err := ReadEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Seq != 2 {
+		t.Errorf("read %+v, want both events on their own line", events)
+	}
+}
@@ -1,5 +1,12 @@
 package gamelogic
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
@@ -14,39 +21,88 @@ const (
 )
 
 type Unit struct {
+	// ID is only unique for the owner, Key is unique in the whole game
 	ID       int
+	Owner    string
 	Rank     UnitRank
 	Location Location
 }
 
+// Key identifies the unit across players, e.g. "alice#3"
+func (u Unit) Key() string {
+	return UnitKey(u.Owner, u.ID)
+}
+
+func UnitKey(owner string, id int) string {
+	return fmt.Sprintf("%v#%v", owner, id)
+}
+
+func ParseUnitKey(key string) (owner string, id int, err error) {
+	i := strings.LastIndex(key, "#")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("error: %s is not a unit key", key)
+	}
+	id, err = strconv.Atoi(key[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("error: %s is not a unit key", key)
+	}
+	return key[:i], id, nil
+}
+
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
 }
 
+type CombatMode string
+
+const (
+	// CombatPower is the classic war: the stack with more power wins and the loser loses every unit
+	CombatPower CombatMode = ""
+	// CombatDice fights rounds of dice rolled from Seed, with casualties on both sides
+	CombatDice CombatMode = "dice"
+)
+
 type RecognitionOfWar struct {
 	Attacker Player
 	Defender Player
+	Mode     CombatMode `json:",omitempty"`
+	// Seed makes the dice roll the same for everyone who resolves this war
+	Seed int64 `json:",omitempty"`
 }
 
-type Location string
+type CommandKind string
 
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
+const (
+	CommandKindSpawn CommandKind = "spawn"
+	CommandKindMove  CommandKind = "move"
+)
+
+// CommandRequest is what a client sends to the server instead of changing its own state.
+// The server checks it against its world and answers with a StateDelta.
+type CommandRequest struct {
+	Kind     CommandKind
+	Username string
+	Location Location
+	Rank     UnitRank // spawn only
+	UnitIDs  []int    // move only
+	// Turn is the turn the order is for, 0 when the game is played in real time
+	Turn        int
+	RequestedAt time.Time
 }
 
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
+// StateDelta is an authoritative change to one player's state sent by the server.
+type StateDelta struct {
+	Username string
+	Spawned  []Unit
+	Moved    []Unit
+	War      *RecognitionOfWar
+	Rejected string // why the player's last command was refused, empty if it was not
+	// Queued is an order that will be carried out when the turn ends
+	Queued *CommandRequest
+	// Resources is what the player has to spend now, nil when it did not change
+	Resources *int
 }
+
+type Location string
@@ -17,7 +17,22 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* path <from> <to>")
+	fmt.Println("    example:")
+	fmt.Println("    path americas australia")
+	fmt.Println("* ally propose|accept|break <username>")
+	fmt.Println("    example:")
+	fmt.Println("    ally propose bob")
+	fmt.Println("* truce propose <username> <seconds>")
+	fmt.Println("* truce accept <username>")
+	fmt.Println("* diplomacy")
+	fmt.Println("* orders")
 	fmt.Println("* status")
+	fmt.Println("* save <name>")
+	fmt.Println("* load <name>")
+	fmt.Println("    example:")
+	fmt.Println("    load autosave")
+	fmt.Println("* sync")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -40,8 +55,41 @@ func ClientWelcome() (string, error) {
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
+	fmt.Println("* match")
+	fmt.Println("* match start <seconds>")
+	fmt.Println("* match end")
+	fmt.Println("* match reset")
+	fmt.Println("* turns start <seconds>")
+	fmt.Println("* turns stop")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* games")
+	fmt.Println("* use <game>")
+	fmt.Println("* logs [user=<name>] [game=<id>] [from=<time>] [to=<time>] [limit=<n>] [text]")
+	fmt.Println("    example:")
+	fmt.Println("    logs user=alice from=15m won a war")
+	fmt.Println("* stats")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
+func PrintLobbyHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* games")
+	fmt.Println("* create [players]")
+	fmt.Println("    example:")
+	fmt.Println("    create 2")
+	fmt.Println("* join <game>")
+	fmt.Println("* find")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
+func PrintLobbyServerHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* games")
+	fmt.Println("* players")
+	fmt.Println("* close <game>")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -86,6 +134,8 @@ func (gs *GameState) CommandStatus() {
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("You have %d resources to spend.\n", gs.Resources())
+	fmt.Printf("The match is %s.\n", gs.Match().Phase)
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
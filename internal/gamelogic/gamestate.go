@@ -1,13 +1,28 @@
 package gamelogic
 
 import (
+	"fmt"
+	"log"
 	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 type GameState struct {
 	Player Player
 	Paused bool
-	mu     *sync.RWMutex
+	// nextUnitID is one more than the highest unit ID this player has had,
+	// IDs of dead units are never given out again
+	nextUnitID int
+	events     EventLog
+	gameMap    *Map
+	catalog    *Catalog
+	resources  int
+	turn       routing.TurnState
+	orders     []CommandRequest
+	diplomacy  *Diplomacy
+	match      routing.MatchState
+	mu         *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,11 +31,64 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:     false,
+		nextUnitID: 1,
+		resources:  StartingResources,
+		gameMap:    DefaultMap(),
+		catalog:    DefaultCatalog(),
+		diplomacy:  NewDiplomacy(),
+		match:      routing.MatchState{Phase: routing.MatchPhaseLobby},
+		mu:         &sync.RWMutex{},
 	}
 }
 
+// RecordEvents appends every change the server makes to this player's state to l from now on
+func (gs *GameState) RecordEvents(l EventLog) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.events = l
+}
+
+// record must not be called with the lock held
+func (gs *GameState) record(e Event) {
+	gs.mu.RLock()
+	events := gs.events
+	gs.mu.RUnlock()
+	if events == nil {
+		return
+	}
+	err := events.Append(e)
+	if err != nil {
+		log.Println("Failed to record event: ", err)
+	}
+}
+
+// SetMap must be the map the server plays on, the client only uses it to catch bad commands early
+func (gs *GameState) SetMap(m *Map) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.gameMap = m
+}
+
+func (gs *GameState) Map() *Map {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.gameMap
+}
+
+// SetCatalog must be the catalog the server plays with, or wars come out differently here
+func (gs *GameState) SetCatalog(c *Catalog) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.catalog = c
+}
+
+func (gs *GameState) Catalog() *Catalog {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.catalog
+}
+
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -39,19 +107,25 @@ func (gs *GameState) isPaused() bool {
 	return gs.Paused
 }
 
-func (gs *GameState) addUnit(u Unit) {
+// addUnit refuses to replace a unit that already has the ID
+func (gs *GameState) addUnit(u Unit) error {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
+	if _, ok := gs.Player.Units[u.ID]; ok {
+		return fmt.Errorf("error: unit %v already exists", UnitKey(gs.Player.Username, u.ID))
+	}
 	gs.Player.Units[u.ID] = u
+	if u.ID >= gs.nextUnitID {
+		gs.nextUnitID = u.ID + 1
+	}
+	return nil
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) removeUnits(units []Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
+	for _, u := range units {
+		delete(gs.Player.Units, u.ID)
 	}
 }
 
@@ -0,0 +1,352 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultRating    = 1000
+	DefaultPlayerCap = 4
+	maxPlayerCap     = 8
+	// ratingSpread is how far a player's rating can be from a game's average to be matched into it
+	ratingSpread = 200
+)
+
+type LobbyRequestKind string
+
+const (
+	LobbyAnnounce LobbyRequestKind = "announce"
+	LobbyList     LobbyRequestKind = "list"
+	LobbyCreate   LobbyRequestKind = "create"
+	LobbyJoin     LobbyRequestKind = "join"
+	// LobbyFind matches the player into the open game closest to their rating
+	LobbyFind  LobbyRequestKind = "find"
+	LobbyLeave LobbyRequestKind = "leave"
+)
+
+// LobbyRequest is sent to the lobby on peril_direct with the lobby key. Rating is only
+// read on announce, Cap on create and GameID on join.
+type LobbyRequest struct {
+	Kind     LobbyRequestKind
+	Username string
+	Rating   int
+	Cap      int
+	GameID   string
+}
+
+type LobbyGame struct {
+	ID      string
+	Cap     int
+	Players []string
+	// Rating is the average rating of the players
+	Rating int
+}
+
+// LobbyReply answers a LobbyRequest. Game and Initial are set once the player is in a game,
+// Initial is the state the player starts the game with. The lobby gets it from the
+// game's server with an AllocateRequest.
+type LobbyReply struct {
+	Games   []LobbyGame
+	Game    *LobbyGame
+	Initial *Snapshot
+	// Created is set when the game was free until now, its server still has to reset it
+	Created bool
+}
+
+// AllocateRequest is sent by the lobby on <game>.allocate once it put a player in the game.
+// The server answers with the player's Snapshot. With Reset it first clears the world,
+// the game was free and whatever is left in it is from its last players.
+type AllocateRequest struct {
+	Username string
+	Reset    bool
+}
+
+type lobbyPlayer struct {
+	rating int
+	game   string
+}
+
+// Lobby hands out the games a server hosts. It only knows their IDs, the games
+// themselves are played on the server.
+type Lobby struct {
+	players map[string]*lobbyPlayer
+	games   map[string]*LobbyGame
+	// free are the IDs of the games nobody is in, in the order they were given
+	free []string
+	mu   *sync.RWMutex
+}
+
+func NewLobby(gameIDs []string) *Lobby {
+	return &Lobby{
+		players: map[string]*lobbyPlayer{},
+		games:   map[string]*LobbyGame{},
+		free:    append([]string{}, gameIDs...),
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (l *Lobby) Handle(req LobbyRequest) (LobbyReply, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if req.Username == "" {
+		return LobbyReply{}, errors.New("error: every request needs a username")
+	}
+	if req.Kind == LobbyAnnounce {
+		return l.announce(req)
+	}
+
+	if _, ok := l.players[req.Username]; !ok {
+		return LobbyReply{}, fmt.Errorf("error: %v has not announced themselves", req.Username)
+	}
+
+	switch req.Kind {
+	case LobbyList:
+		return LobbyReply{Games: l.openGames()}, nil
+	case LobbyCreate:
+		return l.create(req.Username, req.Cap)
+	case LobbyJoin:
+		return l.join(req.Username, req.GameID)
+	case LobbyFind:
+		return l.find(req.Username)
+	case LobbyLeave:
+		l.leave(req.Username)
+		return LobbyReply{}, nil
+	}
+	return LobbyReply{}, fmt.Errorf("error: unknown lobby request %q", req.Kind)
+}
+
+func (l *Lobby) announce(req LobbyRequest) (LobbyReply, error) {
+	rating := req.Rating
+	if rating <= 0 {
+		rating = DefaultRating
+	}
+	p, ok := l.players[req.Username]
+	if !ok {
+		p = &lobbyPlayer{}
+		l.players[req.Username] = p
+	}
+	p.rating = rating
+	if p.game != "" {
+		return l.joined(req.Username, p.game), nil
+	}
+	return LobbyReply{Games: l.openGames()}, nil
+}
+
+// create takes the next free game, the player who created it is its first player
+func (l *Lobby) create(username string, playerCap int) (LobbyReply, error) {
+	if playerCap == 0 {
+		playerCap = DefaultPlayerCap
+	}
+	if playerCap < 2 || playerCap > maxPlayerCap {
+		return LobbyReply{}, fmt.Errorf("error: a game has 2 to %v players", maxPlayerCap)
+	}
+	if len(l.free) == 0 {
+		return LobbyReply{}, errors.New("error: every game is taken, join one instead")
+	}
+
+	id := l.free[0]
+	l.free = l.free[1:]
+	l.games[id] = &LobbyGame{ID: id, Cap: playerCap}
+	reply, err := l.join(username, id)
+	reply.Created = err == nil
+	return reply, err
+}
+
+func (l *Lobby) join(username, id string) (LobbyReply, error) {
+	game, ok := l.games[id]
+	if !ok {
+		return LobbyReply{}, fmt.Errorf("error: there is no open game %v", id)
+	}
+	p := l.players[username]
+	if p.game == id {
+		return l.joined(username, id), nil
+	}
+	if len(game.Players) >= game.Cap {
+		return LobbyReply{}, fmt.Errorf("error: game %v is full", id)
+	}
+
+	l.leave(username)
+	game.Players = append(game.Players, username)
+	p.game = id
+	l.rate(game)
+	return l.joined(username, id), nil
+}
+
+// find joins the open game whose players are closest to the player's rating,
+// or creates a game when none is close enough
+func (l *Lobby) find(username string) (LobbyReply, error) {
+	p := l.players[username]
+	if p.game != "" {
+		return l.joined(username, p.game), nil
+	}
+
+	best := ""
+	bestSpread := ratingSpread + 1
+	for _, game := range l.openGames() {
+		spread := game.Rating - p.rating
+		if spread < 0 {
+			spread = -spread
+		}
+		if spread < bestSpread {
+			best, bestSpread = game.ID, spread
+		}
+	}
+	if best == "" {
+		return l.create(username, DefaultPlayerCap)
+	}
+	return l.join(username, best)
+}
+
+// leave gives the game back once its last player left
+func (l *Lobby) leave(username string) {
+	p := l.players[username]
+	game, ok := l.games[p.game]
+	p.game = ""
+	if !ok {
+		return
+	}
+	for i, name := range game.Players {
+		if name == username {
+			game.Players = append(game.Players[:i], game.Players[i+1:]...)
+			break
+		}
+	}
+	if len(game.Players) == 0 {
+		delete(l.games, game.ID)
+		l.free = append(l.free, game.ID)
+		return
+	}
+	l.rate(game)
+}
+
+// GameOf is the game the player is in
+func (l *Lobby) GameOf(username string) (LobbyGame, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	p, ok := l.players[username]
+	if !ok || p.game == "" {
+		return LobbyGame{}, false
+	}
+	return copyLobbyGame(*l.games[p.game]), true
+}
+
+// Rollback takes the player out of the game a request just put them in, when its server
+// could not set them up, and back into previous, the game GameOf had before. A previous
+// game that was given back when they left it is taken again while it is still free.
+// Without a previous game the player is in no game.
+func (l *Lobby) Rollback(username string, previous LobbyGame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.players[username]
+	if !ok || p.game == previous.ID {
+		return
+	}
+	l.leave(username)
+	if previous.ID == "" {
+		return
+	}
+
+	game, ok := l.games[previous.ID]
+	if !ok {
+		i := slices.Index(l.free, previous.ID)
+		if i < 0 {
+			return
+		}
+		l.free = slices.Delete(l.free, i, i+1)
+		game = &LobbyGame{ID: previous.ID, Cap: previous.Cap}
+		l.games[game.ID] = game
+	}
+	if len(game.Players) >= game.Cap {
+		return
+	}
+	game.Players = append(game.Players, username)
+	p.game = game.ID
+	l.rate(game)
+}
+
+// Close ends the game in the lobby, its players are free to join another one
+func (l *Lobby) Close(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	game, ok := l.games[id]
+	if !ok {
+		return fmt.Errorf("error: there is no open game %v", id)
+	}
+	for _, name := range game.Players {
+		l.players[name].game = ""
+	}
+	delete(l.games, id)
+	l.free = append(l.free, id)
+	return nil
+}
+
+func (l *Lobby) rate(game *LobbyGame) {
+	total := 0
+	for _, name := range game.Players {
+		total += l.players[name].rating
+	}
+	game.Rating = total / len(game.Players)
+}
+
+func (l *Lobby) joined(username, id string) LobbyReply {
+	game := copyLobbyGame(*l.games[id])
+	return LobbyReply{Game: &game}
+}
+
+// openGames are the games with room left, sorted by ID
+func (l *Lobby) openGames() []LobbyGame {
+	games := []LobbyGame{}
+	for _, game := range l.games {
+		if len(game.Players) < game.Cap {
+			games = append(games, copyLobbyGame(*game))
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].ID < games[j].ID
+	})
+	return games
+}
+
+// Games are every game that has players, full ones included
+func (l *Lobby) Games() []LobbyGame {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	games := []LobbyGame{}
+	for _, game := range l.games {
+		games = append(games, copyLobbyGame(*game))
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].ID < games[j].ID
+	})
+	return games
+}
+
+// Players are the ratings of every player who announced themselves
+func (l *Lobby) Players() map[string]int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	players := map[string]int{}
+	for name, p := range l.players {
+		players[name] = p.rating
+	}
+	return players
+}
+
+func copyLobbyGame(game LobbyGame) LobbyGame {
+	game.Players = append([]string{}, game.Players...)
+	return game
+}
+
+func PrintLobbyGames(games []LobbyGame) {
+	if len(games) == 0 {
+		fmt.Println("No open games")
+		return
+	}
+	for _, game := range games {
+		fmt.Printf("* %v: %v/%v players, rating %v %v\n", game.ID, len(game.Players), game.Cap, game.Rating, game.Players)
+	}
+}
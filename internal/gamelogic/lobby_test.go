@@ -0,0 +1,312 @@
+package gamelogic
+
+import (
+	"slices"
+	"testing"
+)
+
+func newTestLobby(t *testing.T, ratings map[string]int, gameIDs ...string) *Lobby {
+	t.Helper()
+	l := NewLobby(gameIDs)
+	for username, rating := range ratings {
+		_, err := l.Handle(LobbyRequest{Kind: LobbyAnnounce, Username: username, Rating: rating})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l
+}
+
+func mustHandle(t *testing.T, l *Lobby, req LobbyRequest) LobbyReply {
+	t.Helper()
+	reply, err := l.Handle(req)
+	if err != nil {
+		t.Fatalf("%v by %v was refused: %v", req.Kind, req.Username, err)
+	}
+	return reply
+}
+
+func TestLobbyCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cap     int
+		wantErr bool
+		wantCap int
+	}{
+		{"default cap", 0, false, DefaultPlayerCap},
+		{"two players", 2, false, 2},
+		{"most players", maxPlayerCap, false, maxPlayerCap},
+		{"alone", 1, true, 0},
+		{"too many players", maxPlayerCap + 1, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLobby(t, map[string]int{"alice": 0}, "g1", "g2")
+			reply, err := l.Handle(LobbyRequest{Kind: LobbyCreate, Username: "alice", Cap: tt.cap})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if games := l.Games(); len(games) != 0 {
+					t.Errorf("the refused game was created: %v", games)
+				}
+				return
+			}
+			if reply.Game == nil || reply.Game.ID != "g1" || reply.Game.Cap != tt.wantCap {
+				t.Fatalf("game = %+v, want g1 with a cap of %v", reply.Game, tt.wantCap)
+			}
+			if !slices.Equal(reply.Game.Players, []string{"alice"}) {
+				t.Errorf("players = %v, want alice", reply.Game.Players)
+			}
+			// the game's server still has to reset the game and hand out alice's starting state
+			if !reply.Created || reply.Initial != nil {
+				t.Errorf("created = %v with initial %+v, want a new game without a state", reply.Created, reply.Initial)
+			}
+		})
+	}
+}
+
+func TestLobbyCreateRunsOutOfGames(t *testing.T) {
+	l := newTestLobby(t, map[string]int{"alice": 0, "bob": 0}, "g1")
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+	_, err := l.Handle(LobbyRequest{Kind: LobbyCreate, Username: "bob"})
+	if err == nil {
+		t.Fatal("bob created a game when every game was taken")
+	}
+
+	// the game is free again once its last player leaves
+	mustHandle(t, l, LobbyRequest{Kind: LobbyLeave, Username: "alice"})
+	reply := mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "bob"})
+	if reply.Game.ID != "g1" || !reply.Created {
+		t.Errorf("bob created %v, want g1 to be created again", reply.Game.ID)
+	}
+}
+
+func TestLobbyJoin(t *testing.T) {
+	tests := []struct {
+		name string
+		// join is who joins g1 after alice created it with a cap of 2
+		join    []string
+		id      string
+		wantErr bool
+		players []string
+	}{
+		{"join", []string{"bob"}, "g1", false, []string{"alice", "bob"}},
+		{"join twice", []string{"bob", "bob"}, "g1", false, []string{"alice", "bob"}},
+		{"full game", []string{"bob", "carol"}, "g1", true, []string{"alice", "bob"}},
+		{"game that is not open", []string{"bob"}, "g2", true, []string{"alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLobby(t, map[string]int{"alice": 0, "bob": 0, "carol": 0}, "g1", "g2")
+			mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice", Cap: 2})
+
+			var reply LobbyReply
+			var err error
+			for _, username := range tt.join {
+				reply, err = l.Handle(LobbyRequest{Kind: LobbyJoin, Username: username, GameID: tt.id})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error %v", err, tt.wantErr)
+			}
+			if reply.Created {
+				t.Error("joining a game created it")
+			}
+			games := l.Games()
+			if len(games) != 1 || !slices.Equal(games[0].Players, tt.players) {
+				t.Errorf("games = %+v, want g1 with %v", games, tt.players)
+			}
+		})
+	}
+}
+
+func TestLobbyJoinLeavesThePreviousGame(t *testing.T) {
+	l := newTestLobby(t, map[string]int{"alice": 0, "bob": 0}, "g1", "g2")
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "bob"})
+	mustHandle(t, l, LobbyRequest{Kind: LobbyJoin, Username: "alice", GameID: "g2"})
+
+	games := l.Games()
+	if len(games) != 1 || games[0].ID != "g2" || !slices.Equal(games[0].Players, []string{"bob", "alice"}) {
+		t.Errorf("games = %+v, want only g2 with bob and alice", games)
+	}
+}
+
+func TestLobbyFind(t *testing.T) {
+	tests := []struct {
+		name   string
+		rating int
+		want   string
+	}{
+		{"closest game", 1250, "g2"},
+		{"other game", 950, "g1"},
+		{"none close enough", 2000, "g3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLobby(t, map[string]int{"alice": 1000, "bob": 1300, "carol": tt.rating}, "g1", "g2", "g3")
+			mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+			mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "bob"})
+
+			reply := mustHandle(t, l, LobbyRequest{Kind: LobbyFind, Username: "carol"})
+			if reply.Game == nil || reply.Game.ID != tt.want {
+				t.Fatalf("carol found %+v, want %v", reply.Game, tt.want)
+			}
+			if reply.Created != (tt.want == "g3") {
+				t.Errorf("created = %v, want it only for a new game", reply.Created)
+			}
+			// finding again keeps the player where they are
+			reply = mustHandle(t, l, LobbyRequest{Kind: LobbyFind, Username: "carol"})
+			if reply.Game.ID != tt.want {
+				t.Errorf("carol moved to %v, want to stay in %v", reply.Game.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestLobbyFindSkipsFullGames(t *testing.T) {
+	l := newTestLobby(t, map[string]int{"alice": 1000, "bob": 1000, "carol": 1000}, "g1", "g2")
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice", Cap: 2})
+	mustHandle(t, l, LobbyRequest{Kind: LobbyJoin, Username: "bob", GameID: "g1"})
+
+	reply := mustHandle(t, l, LobbyRequest{Kind: LobbyFind, Username: "carol"})
+	if reply.Game.ID != "g2" {
+		t.Errorf("carol found %v, want a new game", reply.Game.ID)
+	}
+}
+
+func TestLobbyRating(t *testing.T) {
+	l := newTestLobby(t, map[string]int{"alice": 1000, "bob": 1400, "carol": 0}, "g1")
+	if players := l.Players(); players["carol"] != DefaultRating {
+		t.Errorf("carol's rating is %v, want the default %v", players["carol"], DefaultRating)
+	}
+
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+	reply := mustHandle(t, l, LobbyRequest{Kind: LobbyJoin, Username: "bob", GameID: "g1"})
+	if reply.Game.Rating != 1200 {
+		t.Errorf("the game is rated %v, want 1200", reply.Game.Rating)
+	}
+
+	mustHandle(t, l, LobbyRequest{Kind: LobbyLeave, Username: "alice"})
+	if games := l.Games(); len(games) != 1 || games[0].Rating != 1400 {
+		t.Errorf("games = %+v, want g1 rated 1400 after alice left", games)
+	}
+}
+
+func TestLobbyRefusesRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		req  LobbyRequest
+	}{
+		{"no username", LobbyRequest{Kind: LobbyList}},
+		{"not announced", LobbyRequest{Kind: LobbyList, Username: "dave"}},
+		{"unknown kind", LobbyRequest{Kind: "kick", Username: "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLobby(t, map[string]int{"alice": 0}, "g1")
+			_, err := l.Handle(tt.req)
+			if err == nil {
+				t.Error("the request was accepted")
+			}
+		})
+	}
+}
+
+func TestLobbyClose(t *testing.T) {
+	l := newTestLobby(t, map[string]int{"alice": 0, "bob": 0}, "g1")
+	mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+	mustHandle(t, l, LobbyRequest{Kind: LobbyJoin, Username: "bob", GameID: "g1"})
+
+	err := l.Close("g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if games := l.Games(); len(games) != 0 {
+		t.Errorf("games = %+v after closing, want none", games)
+	}
+	if err := l.Close("g1"); err == nil {
+		t.Error("closed a game that was not open")
+	}
+
+	// the players are free to play again, and so is the game
+	reply := mustHandle(t, l, LobbyRequest{Kind: LobbyFind, Username: "bob"})
+	if reply.Game.ID != "g1" || !slices.Equal(reply.Game.Players, []string{"bob"}) {
+		t.Errorf("bob found %+v, want g1 with only bob in it", reply.Game)
+	}
+}
+
+func TestLobbyRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup puts alice in a game and returns the request whose server fails
+		setup func(t *testing.T, l *Lobby) LobbyRequest
+		want  string
+		// free are the games nobody is in after the rollback
+		free []string
+	}{
+		{
+			name:  "no previous game",
+			setup: func(t *testing.T, l *Lobby) LobbyRequest { return LobbyRequest{Kind: LobbyCreate, Username: "alice"} },
+			want:  "",
+			free:  []string{"g2", "g3", "g1"},
+		},
+		{
+			name: "back to a game with players",
+			setup: func(t *testing.T, l *Lobby) LobbyRequest {
+				mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+				mustHandle(t, l, LobbyRequest{Kind: LobbyJoin, Username: "bob", GameID: "g1"})
+				return LobbyRequest{Kind: LobbyCreate, Username: "alice"}
+			},
+			want: "g1",
+			free: []string{"g3", "g2"},
+		},
+		{
+			name: "back to a game that was given back",
+			setup: func(t *testing.T, l *Lobby) LobbyRequest {
+				mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice", Cap: 2})
+				mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "bob"})
+				return LobbyRequest{Kind: LobbyJoin, Username: "alice", GameID: "g2"}
+			},
+			want: "g1",
+			free: []string{"g3"},
+		},
+		{
+			name: "already in the game",
+			setup: func(t *testing.T, l *Lobby) LobbyRequest {
+				mustHandle(t, l, LobbyRequest{Kind: LobbyCreate, Username: "alice"})
+				return LobbyRequest{Kind: LobbyAnnounce, Username: "alice"}
+			},
+			want: "g1",
+			free: []string{"g2", "g3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLobby(t, map[string]int{"alice": 1000, "bob": 1000}, "g1", "g2", "g3")
+			req := tt.setup(t, l)
+			previous, _ := l.GameOf("alice")
+			mustHandle(t, l, req)
+			l.Rollback("alice", previous)
+
+			got, ok := l.GameOf("alice")
+			if got.ID != tt.want || ok != (tt.want != "") {
+				t.Errorf("alice is in %q, want %q", got.ID, tt.want)
+			}
+			if ok && !slices.Contains(got.Players, "alice") {
+				t.Errorf("game %v has players %v, want alice among them", got.ID, got.Players)
+			}
+			if ok && got.Cap != previous.Cap {
+				t.Errorf("game %v has cap %v, want %v", got.ID, got.Cap, previous.Cap)
+			}
+			if !slices.Equal(l.free, tt.free) {
+				t.Errorf("free games = %v, want %v", l.free, tt.free)
+			}
+		})
+	}
+}
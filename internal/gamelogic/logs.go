@@ -1,32 +1,300 @@
 package gamelogic
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
-const logsFile = "game.log"
+const LogsDir = "logs"
 
-const writeToDiskSleep = 1 * time.Second
+// LogSink stores game logs
+type LogSink interface {
+	Write(gamelog routing.GameLog) error
+	Close() error
+}
+
+type FsyncPolicy int
+
+const (
+	// FsyncNever leaves it to the OS, a crash can lose logs that were already written
+	FsyncNever FsyncPolicy = iota
+	// FsyncBatch syncs after every flushed batch
+	FsyncBatch
+	// FsyncWrite flushes and syncs before Write returns, so a written log is on disk
+	FsyncWrite
+)
 
-func WriteLog(gamelog routing.GameLog) error {
-	log.Printf("received game log...")
-	time.Sleep(writeToDiskSleep)
+type FileLogSinkOptions struct {
+	Dir string
+	// Instance is part of every file name, so servers sharing Dir never write to the same file
+	Instance      string
+	BatchSize     int
+	FlushInterval time.Duration
+	// a file is rotated once it is bigger than MaxFileSize or older than MaxFileAge
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	Fsync       FsyncPolicy
+}
 
-	f, err := os.OpenFile(logsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func DefaultFileLogSinkOptions() FileLogSinkOptions {
+	hostname, err := os.Hostname()
 	if err != nil {
-		return fmt.Errorf("could not open logs file: %v", err)
+		hostname = "server"
 	}
-	defer f.Close()
+	return FileLogSinkOptions{
+		Dir:           LogsDir,
+		Instance:      fmt.Sprintf("%v-%v", hostname, os.Getpid()),
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		MaxFileSize:   10 << 20,
+		MaxFileAge:    24 * time.Hour,
+		Fsync:         FsyncBatch,
+	}
+}
+
+// FileLogSink writes game logs as JSON lines. Logs are buffered and written in batches,
+// either when BatchSize logs are waiting or every FlushInterval.
+type FileLogSink struct {
+	options  FileLogSinkOptions
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	openedAt time.Time
+	pending  int
+	done     chan struct{}
+	wg       sync.WaitGroup
+	mu       *sync.Mutex
+}
+
+func NewFileLogSink(options FileLogSinkOptions) (*FileLogSink, error) {
+	err := os.MkdirAll(options.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create logs directory: %v", err)
+	}
+
+	s := &FileLogSink{
+		options: options,
+		done:    make(chan struct{}),
+		mu:      &sync.Mutex{},
+	}
+	err = s.rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.FlushInterval > 0 {
+		s.wg.Add(1)
+		go s.flushEvery(options.FlushInterval)
+	}
+	return s, nil
+}
 
-	str := fmt.Sprintf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
-	_, err = f.WriteString(str)
+func (s *FileLogSink) Write(gamelog routing.GameLog) error {
+	line, err := json.Marshal(gamelog)
+	if err != nil {
+		return fmt.Errorf("could not encode game log: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+
+	n, err := s.w.Write(append(line, '\n'))
+	s.size += int64(n)
 	if err != nil {
 		return fmt.Errorf("could not write to logs file: %v", err)
 	}
+	s.pending++
+
+	if s.options.Fsync == FsyncWrite || s.pending >= s.options.BatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *FileLogSink) flushEvery(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.flush()
+			s.mu.Unlock()
+			if err != nil {
+				log.Println("Failed to flush game logs: ", err)
+			}
+		}
+	}
+}
+
+// flush is called with the lock held
+func (s *FileLogSink) flush() error {
+	if s.f == nil || s.pending == 0 {
+		return nil
+	}
+	err := s.w.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write to logs file: %v", err)
+	}
+	s.pending = 0
+	if s.options.Fsync != FsyncNever {
+		err = s.f.Sync()
+		if err != nil {
+			return fmt.Errorf("could not sync logs file: %v", err)
+		}
+	}
+
+	if (s.options.MaxFileSize > 0 && s.size >= s.options.MaxFileSize) ||
+		(s.options.MaxFileAge > 0 && time.Since(s.openedAt) >= s.options.MaxFileAge) {
+		return s.rotate()
+	}
+	return nil
+}
+
+// rotate closes the current file and starts a new one, it is called with the lock held
+func (s *FileLogSink) rotate() error {
+	if s.f != nil {
+		err := s.f.Close()
+		if err != nil {
+			return fmt.Errorf("could not close logs file: %v", err)
+		}
+	}
+
+	s.openedAt = time.Now()
+	name := fmt.Sprintf("%v-%v.jsonl", s.options.Instance, s.openedAt.Format("20060102T150405.000"))
+	f, err := os.OpenFile(filepath.Join(s.options.Dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		s.f = nil
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.size = 0
 	return nil
 }
+
+// Close writes whatever is still buffered
+func (s *FileLogSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if err != nil {
+		s.f.Close()
+		s.f = nil
+		return fmt.Errorf("could not write to logs file: %v", err)
+	}
+	if s.options.Fsync != FsyncNever {
+		s.f.Sync()
+	}
+	err = s.f.Close()
+	s.f = nil
+	return err
+}
+
+// LogQuery selects game logs, zero fields match everything
+type LogQuery struct {
+	Username string
+	// Game is the game's ID, or default
+	Game string
+	From time.Time
+	To   time.Time
+	// Text is matched case-insensitively anywhere in the message
+	Text  string
+	Limit int
+}
+
+func (q LogQuery) matches(gamelog routing.GameLog) bool {
+	if q.Username != "" && gamelog.Username != q.Username {
+		return false
+	}
+	if q.Game != "" && q.Game != gamelogGame(gamelog) {
+		return false
+	}
+	if !q.From.IsZero() && gamelog.CurrentTime.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && gamelog.CurrentTime.After(q.To) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(gamelog.Message), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+func gamelogGame(gamelog routing.GameLog) string {
+	if gamelog.Game == "" {
+		return routing.Game{}.String()
+	}
+	return gamelog.Game
+}
+
+// QueryLogs reads every log file in dir, written by any server, and returns the matching
+// logs oldest first. With a limit only the newest logs are returned.
+func QueryLogs(dir string, q LogQuery) ([]routing.GameLog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []routing.GameLog{}
+	for _, path := range paths {
+		found, err := queryLogFile(path, q)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, found...)
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].CurrentTime.Before(logs[j].CurrentTime)
+	})
+	if q.Limit > 0 && len(logs) > q.Limit {
+		logs = logs[len(logs)-q.Limit:]
+	}
+	return logs, nil
+}
+
+func queryLogFile(path string, q LogQuery) ([]routing.GameLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open logs file: %v", err)
+	}
+	defer f.Close()
+
+	logs := []routing.GameLog{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		gamelog := routing.GameLog{}
+		// a server that died mid-write can leave half a line behind
+		if json.Unmarshal(scanner.Bytes(), &gamelog) != nil {
+			continue
+		}
+		if q.matches(gamelog) {
+			logs = append(logs, gamelog)
+		}
+	}
+	return logs, scanner.Err()
+}
@@ -0,0 +1,227 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func testSinkOptions(t *testing.T) FileLogSinkOptions {
+	t.Helper()
+	return FileLogSinkOptions{
+		Dir:       t.TempDir(),
+		Instance:  "test",
+		BatchSize: 100,
+		Fsync:     FsyncNever,
+	}
+}
+
+func newTestSink(t *testing.T, options FileLogSinkOptions) *FileLogSink {
+	t.Helper()
+	s, err := NewFileLogSink(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustWriteLog(t *testing.T, s *FileLogSink, username, message string) {
+	t.Helper()
+	err := s.Write(routing.GameLog{CurrentTime: time.Now(), Username: username, Message: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustQueryLogs(t *testing.T, dir string, q LogQuery) []routing.GameLog {
+	t.Helper()
+	logs, err := QueryLogs(dir, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logs
+}
+
+// logFiles are the files in dir that have at least one log in them
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 0 {
+			written = append(written, path)
+		}
+	}
+	return written
+}
+
+func TestFileLogSinkBatches(t *testing.T) {
+	options := testSinkOptions(t)
+	options.BatchSize = 2
+	s := newTestSink(t, options)
+
+	mustWriteLog(t, s, "alice", "first")
+	if logs := mustQueryLogs(t, options.Dir, LogQuery{}); len(logs) != 0 {
+		t.Fatalf("%v logs are on disk before the batch is full", len(logs))
+	}
+	mustWriteLog(t, s, "alice", "second")
+	if logs := mustQueryLogs(t, options.Dir, LogQuery{}); len(logs) != 2 {
+		t.Fatalf("%v logs are on disk after a full batch, want 2", len(logs))
+	}
+
+	mustWriteLog(t, s, "alice", "third")
+	err := s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs := mustQueryLogs(t, options.Dir, LogQuery{}); len(logs) != 3 {
+		t.Errorf("%v logs are on disk after closing, want 3", len(logs))
+	}
+	if err := s.Write(routing.GameLog{Message: "late"}); err == nil {
+		t.Error("wrote to a closed sink")
+	}
+}
+
+func TestFileLogSinkFlushesEveryInterval(t *testing.T) {
+	options := testSinkOptions(t)
+	options.FlushInterval = 5 * time.Millisecond
+	s := newTestSink(t, options)
+	defer s.Close()
+
+	mustWriteLog(t, s, "alice", "first")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(mustQueryLogs(t, options.Dir, LogQuery{})) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the log was not flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFileLogSinkRotates(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int64
+		age    time.Duration
+		rotate bool
+	}{
+		{"by size", 1, 0, true},
+		{"by age", 0, time.Millisecond, true},
+		{"not before the limits", 1 << 20, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := testSinkOptions(t)
+			options.BatchSize = 1
+			options.MaxFileSize = tt.size
+			options.MaxFileAge = tt.age
+			s := newTestSink(t, options)
+
+			for _, message := range []string{"first", "second", "third"} {
+				// file names have millisecond timestamps
+				time.Sleep(2 * time.Millisecond)
+				mustWriteLog(t, s, "alice", message)
+			}
+			err := s.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := len(logFiles(t, options.Dir))
+			if tt.rotate && files != 3 {
+				t.Errorf("logs were written to %v files, want one each", files)
+			}
+			if !tt.rotate && files != 1 {
+				t.Errorf("logs were written to %v files, want 1", files)
+			}
+			logs := mustQueryLogs(t, options.Dir, LogQuery{})
+			if len(logs) != 3 || logs[0].Message != "first" || logs[2].Message != "third" {
+				t.Errorf("read %v, want the three logs in order", logs)
+			}
+		})
+	}
+}
+
+func TestQueryLogs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	// two servers share the directory and write their logs out of order with each other
+	for _, instance := range []struct {
+		name string
+		logs []routing.GameLog
+	}{
+		{"server-a", []routing.GameLog{
+			{CurrentTime: at(0), Username: "alice", Message: "alice won a war against bob"},
+			{CurrentTime: at(2), Username: "bob", Message: "bob spawned artillery"},
+			{CurrentTime: at(4), Username: "alice", Message: "A war between alice and carol resulted in a draw"},
+		}},
+		{"server-b", []routing.GameLog{
+			{CurrentTime: at(1), Username: "carol", Message: "carol won a WAR against alice"},
+			{CurrentTime: at(3), Username: "alice", Message: "alice moved to asia"},
+		}},
+	} {
+		options := FileLogSinkOptions{Dir: dir, Instance: instance.name, BatchSize: 100, Fsync: FsyncNever}
+		s := newTestSink(t, options)
+		for _, gamelog := range instance.logs {
+			err := s.Write(gamelog)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a server that died mid-write left half a line behind
+	err := os.WriteFile(filepath.Join(dir, "server-c.jsonl"), []byte(`{"CurrentTime":"2026-01-01T12:05:00Z","Userna`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query LogQuery
+		want  []string
+	}{
+		{"everything oldest first", LogQuery{}, []string{"alice", "carol", "bob", "alice", "alice"}},
+		{"one player", LogQuery{Username: "alice"}, []string{"alice", "alice", "alice"}},
+		{"from", LogQuery{From: at(3)}, []string{"alice", "alice"}},
+		{"to", LogQuery{To: at(1)}, []string{"alice", "carol"}},
+		{"from and to", LogQuery{From: at(1), To: at(3)}, []string{"carol", "bob", "alice"}},
+		{"text ignores case", LogQuery{Text: "war"}, []string{"alice", "carol", "alice"}},
+		{"limit keeps the newest", LogQuery{Limit: 2}, []string{"alice", "alice"}},
+		{"everything together", LogQuery{Username: "alice", Text: "war", Limit: 1}, []string{"alice"}},
+		{"nothing matches", LogQuery{Username: "dave"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := mustQueryLogs(t, dir, tt.query)
+			got := []string{}
+			for i, gamelog := range logs {
+				got = append(got, gamelog.Username)
+				if i > 0 && gamelog.CurrentTime.Before(logs[i-1].CurrentTime) {
+					t.Errorf("log %v is older than the one before it", i)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got logs of %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
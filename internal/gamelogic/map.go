@@ -0,0 +1,246 @@
+package gamelogic
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Map is the board: the locations and the edges units travel along.
+// Edges go both ways and cost the same in either direction.
+type Map struct {
+	edges  map[Location]map[Location]int
+	income map[Location]int
+}
+
+// MapFile is the JSON format of a map
+type MapFile struct {
+	Locations []Location `json:"locations"`
+	Edges     []MapEdge  `json:"edges"`
+	// Income is what controlling a location earns every tick, defaultIncome when it is not set
+	Income map[Location]int `json:"income,omitempty"`
+}
+
+const defaultIncome = 1
+
+type MapEdge struct {
+	From Location `json:"from"`
+	To   Location `json:"to"`
+	Cost int      `json:"cost"`
+}
+
+// DefaultMap is the six continents Peril has always had
+func DefaultMap() *Map {
+	m, err := NewMap(MapFile{
+		Locations: []Location{"americas", "europe", "africa", "asia", "australia", "antarctica"},
+		Income:    map[Location]int{"europe": 2, "asia": 2, "antarctica": 0},
+		Edges: []MapEdge{
+			{From: "americas", To: "europe", Cost: 2},
+			{From: "americas", To: "asia", Cost: 2},
+			{From: "americas", To: "antarctica", Cost: 3},
+			{From: "europe", To: "africa", Cost: 1},
+			{From: "europe", To: "asia", Cost: 1},
+			{From: "africa", To: "asia", Cost: 1},
+			{From: "africa", To: "antarctica", Cost: 3},
+			{From: "asia", To: "australia", Cost: 2},
+			{From: "australia", To: "antarctica", Cost: 2},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func NewMap(file MapFile) (*Map, error) {
+	m := &Map{edges: map[Location]map[Location]int{}, income: map[Location]int{}}
+	for _, loc := range file.Locations {
+		if loc == "" {
+			return nil, fmt.Errorf("map has a location without a name")
+		}
+		m.edges[loc] = map[Location]int{}
+		m.income[loc] = defaultIncome
+	}
+	for loc, income := range file.Income {
+		if !m.Has(loc) {
+			return nil, fmt.Errorf("map income uses the unknown location %v", loc)
+		}
+		if income < 0 {
+			return nil, fmt.Errorf("map income of %v can not be negative", loc)
+		}
+		m.income[loc] = income
+	}
+	for _, e := range file.Edges {
+		if !m.Has(e.From) || !m.Has(e.To) {
+			return nil, fmt.Errorf("map edge %v - %v uses an unknown location", e.From, e.To)
+		}
+		if e.Cost < 1 {
+			return nil, fmt.Errorf("map edge %v - %v must cost at least 1", e.From, e.To)
+		}
+		m.edges[e.From][e.To] = e.Cost
+		m.edges[e.To][e.From] = e.Cost
+	}
+	return m, nil
+}
+
+func LoadMap(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read map: %v", err)
+	}
+	file := MapFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode map %v: %v", path, err)
+	}
+	return NewMap(file)
+}
+
+func (m *Map) Has(loc Location) bool {
+	_, ok := m.edges[loc]
+	return ok
+}
+
+// Locations are sorted by name
+func (m *Map) Locations() []Location {
+	locations := []Location{}
+	for loc := range m.edges {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+	return locations
+}
+
+func (m *Map) Neighbors(loc Location) []Location {
+	neighbors := []Location{}
+	for n := range m.edges[loc] {
+		neighbors = append(neighbors, n)
+	}
+	sort.Slice(neighbors, func(i, j int) bool { return neighbors[i] < neighbors[j] })
+	return neighbors
+}
+
+// Income is what controlling the location earns every tick
+func (m *Map) Income(loc Location) int {
+	return m.income[loc]
+}
+
+// Cost of the edge between two adjacent locations
+func (m *Map) Cost(from, to Location) (int, bool) {
+	cost, ok := m.edges[from][to]
+	return cost, ok
+}
+
+// ShortestPath is the cheapest way from one location to another, both included
+func (m *Map) ShortestPath(from, to Location) ([]Location, int, bool) {
+	if !m.Has(from) || !m.Has(to) {
+		return nil, 0, false
+	}
+
+	dist := map[Location]int{from: 0}
+	prev := map[Location]Location{}
+	queue := &pathQueue{{loc: from}}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(pathItem)
+		if current.cost > dist[current.loc] {
+			continue
+		}
+		if current.loc == to {
+			break
+		}
+		for next, cost := range m.edges[current.loc] {
+			d := current.cost + cost
+			if known, ok := dist[next]; ok && known <= d {
+				continue
+			}
+			dist[next] = d
+			prev[next] = current.loc
+			heap.Push(queue, pathItem{loc: next, cost: d})
+		}
+	}
+
+	cost, ok := dist[to]
+	if !ok {
+		return nil, 0, false
+	}
+	path := []Location{to}
+	for loc := to; loc != from; {
+		loc = prev[loc]
+		path = append([]Location{loc}, path...)
+	}
+	return path, cost, true
+}
+
+// validateMove checks the unit can get to the location in one move: along a single
+// edge that costs no more than the unit's movement
+func validateMove(m *Map, c *Catalog, unit Unit, to Location) error {
+	from := unit.Location
+	if !m.Has(to) {
+		return fmt.Errorf("error: %s is not a valid location", to)
+	}
+	if from == to {
+		return nil
+	}
+	if cost, ok := m.Cost(from, to); ok {
+		stats, known := c.Stats(unit.Rank)
+		if !known {
+			return fmt.Errorf("error: %s is not a valid unit", unit.Rank)
+		}
+		if cost > stats.Movement {
+			return fmt.Errorf("error: %s to %s costs %v, %s can only move %v", from, to, cost, unit.Rank, stats.Movement)
+		}
+		return nil
+	}
+
+	path, cost, ok := m.ShortestPath(from, to)
+	if !ok {
+		return fmt.Errorf("error: there is no way from %s to %s", from, to)
+	}
+	steps := []string{}
+	for _, loc := range path {
+		steps = append(steps, string(loc))
+	}
+	return fmt.Errorf("error: %s is not next to %s, the shortest way costs %v: %s", to, from, cost, strings.Join(steps, " -> "))
+}
+
+type pathItem struct {
+	loc  Location
+	cost int
+}
+
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x any)        { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// CommandPath prints the cheapest way between two locations, with the cost of every step
+func (gs *GameState) CommandPath(words []string) error {
+	if len(words) < 3 {
+		return errors.New("usage: path <from> <to>")
+	}
+	m := gs.Map()
+	from, to := Location(words[1]), Location(words[2])
+	path, cost, ok := m.ShortestPath(from, to)
+	if !ok {
+		return fmt.Errorf("error: there is no way from %s to %s", from, to)
+	}
+
+	fmt.Printf("From %s to %s costs %v:\n", from, to, cost)
+	for i := 1; i < len(path); i++ {
+		step, _ := m.Cost(path[i-1], path[i])
+		fmt.Printf("* %s -> %s (%v)\n", path[i-1], path[i], step)
+	}
+	return nil
+}
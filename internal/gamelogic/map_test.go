@@ -0,0 +1,144 @@
+package gamelogic
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// islandMap has a location no edge reaches
+func islandMap(t *testing.T) *Map {
+	t.Helper()
+	m, err := NewMap(MapFile{
+		Locations: []Location{"north", "south", "island"},
+		Edges:     []MapEdge{{From: "north", To: "south", Cost: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestShortestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to Location
+		path     []Location
+		cost     int
+		ok       bool
+	}{
+		{"same location", "europe", "europe", []Location{"europe"}, 0, true},
+		{"adjacent", "europe", "asia", []Location{"europe", "asia"}, 1, true},
+		{"two edges", "europe", "australia", []Location{"europe", "asia", "australia"}, 3, true},
+		{"direct edge is cheaper than the detour", "americas", "antarctica", []Location{"americas", "antarctica"}, 3, true},
+		{"detour is cheaper than the direct edge", "africa", "australia", []Location{"africa", "asia", "australia"}, 3, true},
+		{"unknown start", "atlantis", "europe", nil, 0, false},
+		{"unknown end", "europe", "atlantis", nil, 0, false},
+	}
+
+	m := DefaultMap()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cost, ok := m.ShortestPath(tt.from, tt.to)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !slices.Equal(path, tt.path) {
+				t.Errorf("path = %v, want %v", path, tt.path)
+			}
+			if cost != tt.cost {
+				t.Errorf("cost = %v, want %v", cost, tt.cost)
+			}
+		})
+	}
+}
+
+func TestShortestPathUnreachable(t *testing.T) {
+	_, _, ok := islandMap(t).ShortestPath("north", "island")
+	if ok {
+		t.Error("found a way to a location without edges")
+	}
+}
+
+func TestValidateMove(t *testing.T) {
+	tests := []struct {
+		name string
+		rank UnitRank
+		from Location
+		to   Location
+		// err is part of the error, empty when the move is allowed
+		err string
+	}{
+		{"adjacent", RankInfantry, "europe", "asia", ""},
+		{"staying put", RankInfantry, "europe", "europe", ""},
+		{"edge within movement", RankCavalry, "americas", "antarctica", ""},
+		{"edge beyond movement", RankInfantry, "americas", "antarctica", "costs 3, infantry can only move 2"},
+		{"not adjacent names the shortest way", RankInfantry, "europe", "australia", "the shortest way costs 3: europe -> asia -> australia"},
+		{"unknown location", RankInfantry, "europe", "atlantis", "atlantis is not a valid location"},
+		{"unknown rank", "dragon", "europe", "asia", "dragon is not a valid unit"},
+	}
+
+	m, c := DefaultMap(), DefaultCatalog()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMove(m, c, Unit{ID: 1, Rank: tt.rank, Location: tt.from}, tt.to)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("move refused: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateMoveWithoutAWay(t *testing.T) {
+	err := validateMove(islandMap(t), DefaultCatalog(), Unit{ID: 1, Rank: RankInfantry, Location: "north"}, "island")
+	if err == nil || !strings.Contains(err.Error(), "there is no way from north to island") {
+		t.Errorf("err = %v, want no way", err)
+	}
+}
+
+func TestWorldRefusesMovesThatAreNotAdjacent(t *testing.T) {
+	w := newTestWorld(t)
+	unit := mustSpawn(t, w, "alice", "europe", RankInfantry)
+
+	_, _, err := w.Move("alice", "australia", []int{unit.ID})
+	if err == nil {
+		t.Fatal("the world moved a unit two edges at once")
+	}
+	p, _ := w.Player("alice")
+	if p.Units[unit.ID].Location != "europe" {
+		t.Errorf("the refused unit is in %v, want europe", p.Units[unit.ID].Location)
+	}
+
+	_, _, err = w.Move("alice", "asia", []int{unit.ID})
+	if err != nil {
+		t.Fatalf("the world refused a move along an edge: %v", err)
+	}
+}
+
+func TestNewMapRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file MapFile
+	}{
+		{"location without a name", MapFile{Locations: []Location{""}}},
+		{"edge to an unknown location", MapFile{Locations: []Location{"a"}, Edges: []MapEdge{{From: "a", To: "b", Cost: 1}}}},
+		{"free edge", MapFile{Locations: []Location{"a", "b"}, Edges: []MapEdge{{From: "a", To: "b", Cost: 0}}}},
+		{"income of an unknown location", MapFile{Locations: []Location{"a"}, Income: map[Location]int{"b": 1}}},
+		{"negative income", MapFile{Locations: []Location{"a"}, Income: map[Location]int{"a": -1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMap(tt.file)
+			if err == nil {
+				t.Error("the map was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type VictoryKind string
+
+const (
+	// VictoryControl is won by the first player to control Locations locations
+	VictoryControl VictoryKind = "control"
+	// VictoryEliminate is won by the last player with units left
+	VictoryEliminate VictoryKind = "eliminate"
+	// VictoryScore is won by the best score when the time limit runs out
+	VictoryScore VictoryKind = "score"
+)
+
+// locationScore is what every controlled location adds to a player's score
+const locationScore = 5
+
+type VictoryCondition struct {
+	Kind      VictoryKind
+	Locations int
+	// TimeLimit ends the match with the best score winning, 0 is no limit
+	TimeLimit time.Duration
+}
+
+// DefaultVictory is the last player standing, without a time limit
+func DefaultVictory() VictoryCondition {
+	return VictoryCondition{Kind: VictoryEliminate}
+}
+
+func (v VictoryCondition) Validate() error {
+	switch v.Kind {
+	case VictoryControl:
+		if v.Locations < 1 {
+			return errors.New("error: control victory needs at least 1 location")
+		}
+	case VictoryEliminate:
+	case VictoryScore:
+		if v.TimeLimit <= 0 {
+			return errors.New("error: score victory needs a time limit")
+		}
+	default:
+		return fmt.Errorf("error: unknown victory condition %q", v.Kind)
+	}
+	if v.TimeLimit < 0 {
+		return errors.New("error: the time limit can not be negative")
+	}
+	return nil
+}
+
+func (v VictoryCondition) String() string {
+	condition := ""
+	switch v.Kind {
+	case VictoryControl:
+		condition = fmt.Sprintf("control %v locations", v.Locations)
+	case VictoryEliminate:
+		condition = "eliminate every opponent"
+	case VictoryScore:
+		return fmt.Sprintf("best score after %v", v.TimeLimit)
+	}
+	if v.TimeLimit > 0 {
+		condition += fmt.Sprintf(", or best score after %v", v.TimeLimit)
+	}
+	return condition
+}
+
+// SetVictory decides how the next match is won
+func (w *World) SetVictory(v VictoryCondition) error {
+	err := v.Validate()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase != routing.MatchPhaseLobby {
+		return errors.New("error: the victory condition can only change in the lobby")
+	}
+	w.victory = v
+	w.match.Victory = v.String()
+	return nil
+}
+
+func (w *World) Match() routing.MatchState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.matchState()
+}
+
+// matchState is a copy so the standings are not shared, it is called with the lock held
+func (w *World) matchState() routing.MatchState {
+	ms := w.match
+	ms.Standings = append([]routing.Standing(nil), w.match.Standings...)
+	return ms
+}
+
+// CountdownMatch leaves the lobby, the match starts once StartMatch is called at startsAt.
+// A finished match stays finished until ResetMatch.
+func (w *World) CountdownMatch(startsAt time.Time) (routing.MatchState, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase != routing.MatchPhaseLobby {
+		return routing.MatchState{}, fmt.Errorf("error: the match can not start while it is %v", w.match.Phase)
+	}
+	w.match.Phase = routing.MatchPhaseStarting
+	w.match.StartsAt = startsAt
+	return w.matchState(), nil
+}
+
+func (w *World) StartMatch(now time.Time) (routing.MatchState, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase != routing.MatchPhaseLobby && w.match.Phase != routing.MatchPhaseStarting {
+		return routing.MatchState{}, fmt.Errorf("error: the match can not start while it is %v", w.match.Phase)
+	}
+	w.match.Phase = routing.MatchPhaseInProgress
+	w.match.StartsAt = time.Time{}
+	if w.victory.TimeLimit > 0 {
+		w.match.EndsAt = now.Add(w.victory.TimeLimit)
+	}
+	return w.matchState(), nil
+}
+
+// CheckVictory finishes the match once someone won it or the time ran out
+func (w *World) CheckVictory(now time.Time) (routing.MatchState, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase != routing.MatchPhaseInProgress {
+		return routing.MatchState{}, false
+	}
+	standings := w.standings()
+
+	switch w.victory.Kind {
+	case VictoryControl:
+		// like eliminate, a player alone in the match has nobody to win against
+		for _, s := range standings {
+			if len(standings) >= 2 && s.Locations >= w.victory.Locations {
+				return w.finish(standings, s.Username, fmt.Sprintf("%v controls %v locations", s.Username, s.Locations)), true
+			}
+		}
+	case VictoryEliminate:
+		alive := []string{}
+		for _, s := range standings {
+			if s.Units > 0 {
+				alive = append(alive, s.Username)
+			}
+		}
+		if len(standings) >= 2 && len(alive) == 1 {
+			return w.finish(standings, alive[0], fmt.Sprintf("%v eliminated every opponent", alive[0])), true
+		}
+		if len(standings) >= 2 && len(alive) == 0 {
+			return w.finish(standings, "", "every army was destroyed"), true
+		}
+	}
+
+	if !w.match.EndsAt.IsZero() && !now.Before(w.match.EndsAt) {
+		return w.finish(standings, bestScore(standings), "the time ran out"), true
+	}
+	return routing.MatchState{}, false
+}
+
+// EndMatch finishes the match early, the best score wins
+func (w *World) EndMatch() (routing.MatchState, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase != routing.MatchPhaseInProgress {
+		return routing.MatchState{}, errors.New("error: there is no match in progress")
+	}
+	standings := w.standings()
+	return w.finish(standings, bestScore(standings), "the server ended the match"), nil
+}
+
+// ResetMatch clears the world for the next match and goes back to the lobby. Every unit,
+// resource and treaty of the last match is gone, the victory condition stays.
+// A countdown is called off, a match in progress has to be ended first.
+func (w *World) ResetMatch() (routing.MatchState, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.match.Phase == routing.MatchPhaseInProgress {
+		return routing.MatchState{}, errors.New("error: the match is in progress, end it first")
+	}
+	w.players = map[string]*Player{}
+	w.nextUnitIDs = map[string]int{}
+	w.resources = map[string]int{}
+	w.diplomacy = NewDiplomacy()
+	w.orders = nil
+	w.match = routing.MatchState{Phase: routing.MatchPhaseLobby, Victory: w.victory.String()}
+	w.record(Event{Kind: EventStateLoaded, Units: []Unit{}})
+	return w.matchState(), nil
+}
+
+// finish is called with the lock held
+func (w *World) finish(standings []routing.Standing, winner, reason string) routing.MatchState {
+	w.match.Phase = routing.MatchPhaseFinished
+	w.match.Winner = winner
+	w.match.Reason = reason
+	w.match.Standings = standings
+	return w.matchState()
+}
+
+// playing is called with the lock held, units only change while a match is in progress
+func (w *World) playing() error {
+	if w.match.Phase != routing.MatchPhaseInProgress {
+		return fmt.Errorf("there is no match in progress, the match is %v", w.match.Phase)
+	}
+	return nil
+}
+
+// standings are best score first, it is called with the lock held
+func (w *World) standings() []routing.Standing {
+	controlled := map[string]int{}
+	for _, location := range w.gameMap.Locations() {
+		owner, ok := w.controller(location)
+		if ok {
+			controlled[owner]++
+		}
+	}
+
+	standings := []routing.Standing{}
+	for _, name := range w.usernames() {
+		score := w.resources[name] + controlled[name]*locationScore
+		for _, unit := range w.players[name].Units {
+			stats, _ := w.catalog.Stats(unit.Rank)
+			score += stats.Cost
+		}
+		standings = append(standings, routing.Standing{
+			Username:  name,
+			Units:     len(w.players[name].Units),
+			Locations: controlled[name],
+			Score:     score,
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Score > standings[j].Score
+	})
+	return standings
+}
+
+// bestScore is nobody when the best score is shared
+func bestScore(standings []routing.Standing) string {
+	if len(standings) == 0 {
+		return ""
+	}
+	if len(standings) > 1 && standings[0].Score == standings[1].Score {
+		return ""
+	}
+	return standings[0].Username
+}
+
+// HandleMatch keeps the match the server announced, it is announced again every few
+// seconds so only changes are shown
+func (gs *GameState) HandleMatch(ms routing.MatchState) {
+	gs.mu.Lock()
+	changed := gs.match.Phase != ms.Phase
+	gs.match = ms
+	// the world is empty in the lobby, whatever is left is from the last match
+	reset := changed && ms.Phase == routing.MatchPhaseLobby
+	if reset {
+		gs.Player.Units = map[int]Unit{}
+		gs.nextUnitID = 1
+		gs.resources = StartingResources
+		gs.diplomacy = NewDiplomacy()
+		gs.orders = nil
+	}
+	gs.mu.Unlock()
+	if reset {
+		gs.record(Event{Kind: EventStateLoaded, Username: gs.GetUsername(), Units: []Unit{}})
+	}
+	if !changed {
+		return
+	}
+
+	switch ms.Phase {
+	case routing.MatchPhaseLobby:
+		fmt.Printf("Waiting in the lobby, victory: %s\n", ms.Victory)
+	case routing.MatchPhaseStarting:
+		fmt.Printf("The match starts at %s, victory: %s\n", ms.StartsAt.Format(time.TimeOnly), ms.Victory)
+	case routing.MatchPhaseInProgress:
+		fmt.Printf("The match has started! Victory: %s\n", ms.Victory)
+		if !ms.EndsAt.IsZero() {
+			fmt.Printf("It ends at %s\n", ms.EndsAt.Format(time.TimeOnly))
+		}
+	case routing.MatchPhaseFinished:
+		printStandings(ms)
+	}
+}
+
+func printStandings(ms routing.MatchState) {
+	if ms.Winner != "" {
+		fmt.Printf("The match is over, %s won: %s\n", ms.Winner, ms.Reason)
+	} else {
+		fmt.Printf("The match is over without a winner: %s\n", ms.Reason)
+	}
+	for i, s := range ms.Standings {
+		fmt.Printf("%v. %v: score %v, %v location(s), %v unit(s)\n", i+1, s.Username, s.Score, s.Locations, s.Units)
+	}
+}
+
+func (gs *GameState) Match() routing.MatchState {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.match
+}
+
+// inMatch is checked before a command is sent, the server checks again
+func (gs *GameState) inMatch() error {
+	phase := gs.Match().Phase
+	if phase != routing.MatchPhaseInProgress {
+		return fmt.Errorf("there is no match in progress, the match is %v", phase)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// wipeOut kills every unit of the player, the player stays in the match
+func wipeOut(w *World, username string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.players[username].Units = map[int]Unit{}
+}
+
+func TestCheckVictory(t *testing.T) {
+	tests := []struct {
+		name    string
+		victory VictoryCondition
+		setup   func(t *testing.T, w *World)
+		// after is how long after the start the victory is checked
+		after  time.Duration
+		over   bool
+		winner string
+	}{
+		{
+			name:    "eliminate: last player with units",
+			victory: VictoryCondition{Kind: VictoryEliminate},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+				wipeOut(w, "bob")
+			},
+			over:   true,
+			winner: "alice",
+		},
+		{
+			name:    "eliminate: everyone still has units",
+			victory: VictoryCondition{Kind: VictoryEliminate},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+			},
+		},
+		{
+			name:    "eliminate: one player alone has not won",
+			victory: VictoryCondition{Kind: VictoryEliminate},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+			},
+		},
+		{
+			name:    "eliminate: a refused command does not join the match",
+			victory: VictoryCondition{Kind: VictoryEliminate},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				_, _, err := w.Move("bob", "asia", []int{1})
+				if err == nil {
+					t.Fatal("bob moved a unit that was never spawned")
+				}
+				_, err = w.Spawn("bob", "atlantis", RankInfantry)
+				if err == nil {
+					t.Fatal("bob spawned in a location that does not exist")
+				}
+			},
+		},
+		{
+			name:    "eliminate: every army destroyed",
+			victory: VictoryCondition{Kind: VictoryEliminate},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+				wipeOut(w, "alice")
+				wipeOut(w, "bob")
+			},
+			over: true,
+		},
+		{
+			name:    "control: enough locations",
+			victory: VictoryCondition{Kind: VictoryControl, Locations: 2},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "alice", "asia", RankInfantry)
+				mustSpawn(t, w, "bob", "africa", RankInfantry)
+			},
+			over:   true,
+			winner: "alice",
+		},
+		{
+			name:    "control: one player alone has not won",
+			victory: VictoryCondition{Kind: VictoryControl, Locations: 2},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "alice", "asia", RankInfantry)
+			},
+		},
+		{
+			name:    "control: contested locations do not count",
+			victory: VictoryCondition{Kind: VictoryControl, Locations: 2},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "alice", "asia", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+			},
+		},
+		{
+			name:    "score: before the time limit",
+			victory: VictoryCondition{Kind: VictoryScore, TimeLimit: time.Minute},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+			},
+			after: 59 * time.Second,
+		},
+		{
+			name:    "score: best score at the time limit",
+			victory: VictoryCondition{Kind: VictoryScore, TimeLimit: time.Minute},
+			setup: func(t *testing.T, w *World) {
+				// what is spent on units still counts, so only alice's hold on europe makes a difference
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+				mustSpawn(t, w, "alice", "asia", RankInfantry)
+			},
+			after:  time.Minute,
+			over:   true,
+			winner: "alice",
+		},
+		{
+			name:    "score: a shared best score has no winner",
+			victory: VictoryCondition{Kind: VictoryScore, TimeLimit: time.Minute},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+			},
+			after: time.Minute,
+			over:  true,
+		},
+		{
+			name:    "time limit ends an eliminate match",
+			victory: VictoryCondition{Kind: VictoryEliminate, TimeLimit: time.Minute},
+			setup: func(t *testing.T, w *World) {
+				mustSpawn(t, w, "alice", "europe", RankInfantry)
+				mustSpawn(t, w, "alice", "americas", RankInfantry)
+				mustSpawn(t, w, "bob", "asia", RankInfantry)
+			},
+			after:  2 * time.Minute,
+			over:   true,
+			winner: "alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorld()
+			err := w.SetVictory(tt.victory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			_, err = w.StartMatch(start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, w)
+
+			ms, over := w.CheckVictory(start.Add(tt.after))
+			if over != tt.over {
+				t.Fatalf("over = %v, want %v", over, tt.over)
+			}
+			if !over {
+				if phase := w.Match().Phase; phase != routing.MatchPhaseInProgress {
+					t.Errorf("the match is %v, want it still in progress", phase)
+				}
+				return
+			}
+			if ms.Phase != routing.MatchPhaseFinished {
+				t.Errorf("the match is %v, want finished", ms.Phase)
+			}
+			if ms.Winner != tt.winner {
+				t.Errorf("winner = %q, want %q (%v)", ms.Winner, tt.winner, ms.Reason)
+			}
+			if len(ms.Standings) != len(w.Usernames()) {
+				t.Errorf("%v standings for %v players", len(ms.Standings), len(w.Usernames()))
+			}
+		})
+	}
+}
+
+func TestVictoryConditionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		victory VictoryCondition
+		valid   bool
+	}{
+		{"eliminate", VictoryCondition{Kind: VictoryEliminate}, true},
+		{"control", VictoryCondition{Kind: VictoryControl, Locations: 3}, true},
+		{"control without locations", VictoryCondition{Kind: VictoryControl}, false},
+		{"score", VictoryCondition{Kind: VictoryScore, TimeLimit: time.Minute}, true},
+		{"score without a time limit", VictoryCondition{Kind: VictoryScore}, false},
+		{"negative time limit", VictoryCondition{Kind: VictoryEliminate, TimeLimit: -time.Minute}, false},
+		{"unknown kind", VictoryCondition{Kind: "surrender"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.victory.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("err = %v, want valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestResetMatch(t *testing.T) {
+	w := NewWorld()
+	err := w.SetVictory(VictoryCondition{Kind: VictoryControl, Locations: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.StartMatch(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+
+	_, err = w.ResetMatch()
+	if err == nil {
+		t.Fatal("a match in progress was reset")
+	}
+	_, err = w.EndMatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := w.ResetMatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Phase != routing.MatchPhaseLobby {
+		t.Errorf("the match is %v after the reset, want lobby", ms.Phase)
+	}
+	if ms.Victory != "control 3 locations" {
+		t.Errorf("victory = %q, want the same condition as before", ms.Victory)
+	}
+	if names := w.Usernames(); len(names) != 0 {
+		t.Errorf("%v are still in the world", names)
+	}
+	if got := w.Resources("alice"); got != StartingResources {
+		t.Errorf("alice has %v resources, want %v", got, StartingResources)
+	}
+
+	_, err = w.StartMatch(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit := mustSpawn(t, w, "alice", "europe", RankInfantry); unit.ID != 1 {
+		t.Errorf("alice's first unit of the next match got ID %v, want 1", unit.ID)
+	}
+}
+
+func TestClientResetsInTheLobby(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.HandleMatch(routing.MatchState{Phase: routing.MatchPhaseInProgress})
+	gs.HandleDelta(StateDelta{Username: "alice", Spawned: []Unit{{ID: 1, Owner: "alice", Rank: RankInfantry, Location: "europe"}}})
+	gs.setResources(3)
+
+	// the units stay until the server goes back to the lobby
+	gs.HandleMatch(routing.MatchState{Phase: routing.MatchPhaseFinished})
+	if len(gs.GetPlayerSnap().Units) != 1 {
+		t.Fatal("the units are gone before the reset")
+	}
+	gs.HandleMatch(routing.MatchState{Phase: routing.MatchPhaseLobby})
+	if units := gs.GetPlayerSnap().Units; len(units) != 0 {
+		t.Errorf("%v units are left in the lobby", len(units))
+	}
+	if gs.Resources() != StartingResources {
+		t.Errorf("%v resources in the lobby, want %v", gs.Resources(), StartingResources)
+	}
+}
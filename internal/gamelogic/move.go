@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 type MoveOutcome int
@@ -12,6 +13,8 @@ const (
 	MoveOutcomeSamePlayer MoveOutcome = iota
 	MoveOutComeSafe
 	MoveOutcomeMakeWar
+	// MoveOutcomeAllied is a move by an ally or a player in a truce, their units share locations without a war
+	MoveOutcomeAllied
 )
 
 func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
@@ -22,17 +25,24 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	fmt.Println("==== Move Detected ====")
 	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
 	for _, unit := range move.Units {
-		fmt.Printf("* %v\n", unit.Rank)
+		fmt.Printf("* %v (%v)\n", unit.Rank, unit.Key())
 	}
 
 	if player.Username == move.Player.Username {
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
-		return MoveOutcomeMakeWar
+	if gs.Relation(move.Player.Username) != RelationWar {
+		fmt.Printf("%s is at peace with you, there is no war.\n", move.Player.Username)
+		return MoveOutcomeAllied
+	}
+
+	// moves only show the units that moved, so only where they arrive matters
+	for _, unit := range player.Units {
+		if unit.Location == move.ToLocation {
+			fmt.Printf("You have units in %s! You are at war with %s!\n", move.ToLocation, move.Player.Username)
+			return MoveOutcomeMakeWar
+		}
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
@@ -49,44 +59,51 @@ func getOverlappingLocation(p1 Player, p2 Player) Location {
 	return ""
 }
 
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+// CommandMove only builds the request, the units move once the server agrees
+func (gs *GameState) CommandMove(words []string) (CommandRequest, error) {
 	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+		return CommandRequest{}, errors.New("the game is paused, you can not move units")
+	}
+	err := gs.inMatch()
+	if err != nil {
+		return CommandRequest{}, err
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return CommandRequest{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	if !gs.Map().Has(newLocation) {
+		return CommandRequest{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			// a full unit key works too, as long as it is one of ours
+			owner, keyID, keyErr := ParseUnitKey(id)
+			if keyErr != nil || owner != gs.GetUsername() {
+				return CommandRequest{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			}
+			unitID = keyID
 		}
-		unitIDs = append(unitIDs, unitID)
-	}
-
-	newUnits := []Unit{}
-	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
-			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+			return CommandRequest{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+		err = validateMove(gs.Map(), gs.Catalog(), unit, newLocation)
+		if err != nil {
+			return CommandRequest{}, err
 		}
-		unit.Location = newLocation
-		gs.UpdateUnit(unit)
-		newUnits = append(newUnits, unit)
+		unitIDs = append(unitIDs, unitID)
 	}
 
-	mv := ArmyMove{
-		ToLocation: newLocation,
-		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
-	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	return CommandRequest{
+		Kind:        CommandKindMove,
+		Username:    gs.GetUsername(),
+		Location:    newLocation,
+		UnitIDs:     unitIDs,
+		Turn:        gs.Turn(),
+		RequestedAt: time.Now(),
+	}, nil
 }
@@ -12,8 +12,10 @@ func (gs *GameState) HandlePause(ps routing.PlayingState) {
 	if ps.IsPaused {
 		fmt.Println("==== Pause Detected ====")
 		gs.pauseGame()
+		gs.record(Event{Kind: EventGamePaused})
 	} else {
 		fmt.Println("==== Resume Detected ====")
 		gs.resumeGame()
+		gs.record(Event{Kind: EventGameResumed})
 	}
 }
@@ -0,0 +1,334 @@
+package gamelogic
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// SnapshotVersion is written into every snapshot. Loading refuses snapshots from a
+// newer version, older ones are upgraded while loading.
+const SnapshotVersion = 5
+
+const snapshotsDir = "snapshots"
+
+// Snapshot is everything needed to rebuild a GameState
+type Snapshot struct {
+	Version    int
+	SavedAt    time.Time
+	Player     Player
+	Paused     bool
+	NextUnitID int
+	Resources  int
+	// Diplomacy is only the treaties and proposals the player takes part in
+	Diplomacy DiplomacySnapshot
+}
+
+// WorldSnapshot is everything needed to rebuild the server's World
+type WorldSnapshot struct {
+	Version     int
+	SavedAt     time.Time
+	Players     map[string]Player
+	NextUnitIDs map[string]int
+	Resources   map[string]int
+	Paused      bool
+	Match       routing.MatchState
+	Diplomacy   DiplomacySnapshot
+}
+
+// SnapshotPath is where a named snapshot of username is kept. A name without an
+// extension is saved as JSON, name.gob is saved with gob.
+func SnapshotPath(username, name string) string {
+	if filepath.Ext(name) == "" {
+		name += ".json"
+	}
+	return filepath.Join(snapshotsDir, username, filepath.Base(name))
+}
+
+func (gs *GameState) Snapshot() Snapshot {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	units := map[int]Unit{}
+	for k, v := range gs.Player.Units {
+		units[k] = v
+	}
+	return Snapshot{
+		Version:    SnapshotVersion,
+		SavedAt:    time.Now(),
+		Player:     Player{Username: gs.Player.Username, Units: units},
+		Paused:     gs.Paused,
+		NextUnitID: gs.nextUnitID,
+		Resources:  gs.resources,
+		Diplomacy:  gs.diplomacy.Snapshot(time.Now()),
+	}
+}
+
+// Restore replaces the state with the snapshot. The snapshot must belong to the same player.
+func (gs *GameState) Restore(snap Snapshot) error {
+	if snap.Player.Username != gs.GetUsername() {
+		return fmt.Errorf("snapshot belongs to %v, not %v", snap.Player.Username, gs.GetUsername())
+	}
+
+	units := map[int]Unit{}
+	nextUnitID := snap.NextUnitID
+	for k, v := range snap.Player.Units {
+		units[k] = v
+		if v.ID >= nextUnitID {
+			nextUnitID = v.ID + 1
+		}
+	}
+
+	gs.mu.Lock()
+	gs.Player.Units = units
+	gs.Paused = snap.Paused
+	gs.nextUnitID = nextUnitID
+	gs.resources = snap.Resources
+	gs.diplomacy = RestoreDiplomacy(snap.Diplomacy)
+	gs.mu.Unlock()
+
+	loaded := []Unit{}
+	for _, u := range units {
+		loaded = append(loaded, u)
+	}
+	gs.record(Event{Kind: EventStateLoaded, Username: gs.Player.Username, Units: loaded})
+	return nil
+}
+
+func (gs *GameState) Save(path string) error {
+	return writeSnapshot(path, gs.Snapshot())
+}
+
+func (gs *GameState) Load(path string) error {
+	snap, err := ReadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	return gs.Restore(snap)
+}
+
+// ReadSnapshot reads a snapshot saved by Save and upgrades it to this version
+func ReadSnapshot(path string) (Snapshot, error) {
+	snap := Snapshot{}
+	err := readSnapshot(path, &snap)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snap.Version > SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("snapshot %v has version %v, this build reads up to %v", path, snap.Version, SnapshotVersion)
+	}
+	upgradePlayer(snap.Version, &snap.Player)
+	if snap.Version < 3 {
+		// there were no resources before version 3
+		snap.Resources = StartingResources
+	}
+	return snap, nil
+}
+
+// Reconcile restores the server's snapshot in place of a loaded one. The server's world
+// is what counts, so the loaded units it does not have are returned instead of kept.
+func (gs *GameState) Reconcile(loaded, server Snapshot) ([]Unit, error) {
+	if loaded.Player.Username != gs.GetUsername() {
+		return nil, fmt.Errorf("snapshot belongs to %v, not %v", loaded.Player.Username, gs.GetUsername())
+	}
+	err := gs.Restore(server)
+	if err != nil {
+		return nil, err
+	}
+
+	gone := []Unit{}
+	for id, unit := range loaded.Player.Units {
+		if _, ok := server.Player.Units[id]; !ok {
+			gone = append(gone, unit)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i].ID < gone[j].ID })
+	return gone, nil
+}
+
+func (w *World) Snapshot() WorldSnapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	players := map[string]Player{}
+	for name, p := range w.players {
+		players[name] = snapshot(p)
+	}
+	nextUnitIDs := map[string]int{}
+	for name, id := range w.nextUnitIDs {
+		nextUnitIDs[name] = id
+	}
+	resources := map[string]int{}
+	for name, r := range w.resources {
+		resources[name] = r
+	}
+	return WorldSnapshot{
+		Version:     SnapshotVersion,
+		SavedAt:     time.Now(),
+		Players:     players,
+		NextUnitIDs: nextUnitIDs,
+		Resources:   resources,
+		Paused:      w.paused,
+		Match:       w.matchState(),
+		Diplomacy:   w.diplomacy.Snapshot(time.Now()),
+	}
+}
+
+// SyncRequest is sent by a client on <game>.sync, the server answers with the player's Snapshot
+type SyncRequest struct {
+	Username string
+}
+
+// PlayerSnapshot is the player's state as the world has it, for a client that joins the game
+func (w *World) PlayerSnapshot(username string) Snapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	player := Player{Username: username, Units: map[int]Unit{}}
+	if p, ok := w.players[username]; ok {
+		player = snapshot(p)
+	}
+	return Snapshot{
+		Version:    SnapshotVersion,
+		SavedAt:    time.Now(),
+		Player:     player,
+		Paused:     w.paused,
+		NextUnitID: w.nextUnitID(username),
+		Resources:  w.resourcesOf(username),
+		Diplomacy:  w.diplomacy.Snapshot(time.Now()).Involving(username),
+	}
+}
+
+func (w *World) Restore(snap WorldSnapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.players = map[string]*Player{}
+	w.nextUnitIDs = map[string]int{}
+	w.resources = map[string]int{}
+	for name, p := range snap.Players {
+		p := p
+		w.players[name] = &p
+		w.nextUnitIDs[name] = snap.NextUnitIDs[name]
+		w.resources[name] = snap.Resources[name]
+		for _, u := range p.Units {
+			if u.ID >= w.nextUnitIDs[name] {
+				w.nextUnitIDs[name] = u.ID + 1
+			}
+		}
+	}
+	w.paused = snap.Paused
+	w.diplomacy = RestoreDiplomacy(snap.Diplomacy)
+	w.match = snap.Match
+	w.match.Victory = w.victory.String()
+
+	units := []Unit{}
+	for _, p := range w.players {
+		for _, u := range p.Units {
+			units = append(units, u)
+		}
+	}
+	w.record(Event{Kind: EventStateLoaded, Units: units})
+}
+
+func (w *World) Save(path string) error {
+	return writeSnapshot(path, w.Snapshot())
+}
+
+func (w *World) Load(path string) error {
+	snap := WorldSnapshot{}
+	err := readSnapshot(path, &snap)
+	if err != nil {
+		return err
+	}
+	if snap.Version > SnapshotVersion {
+		return fmt.Errorf("snapshot %v has version %v, this build reads up to %v", path, snap.Version, SnapshotVersion)
+	}
+	for name, p := range snap.Players {
+		upgradePlayer(snap.Version, &p)
+		snap.Players[name] = p
+	}
+	if snap.Version < 3 {
+		snap.Resources = map[string]int{}
+		for name := range snap.Players {
+			snap.Resources[name] = StartingResources
+		}
+	}
+	if snap.Version < 4 {
+		// there were no matches before version 4, the game was always being played
+		snap.Match = routing.MatchState{Phase: routing.MatchPhaseInProgress}
+	}
+	// treaties were not kept before version 5, everyone is at war again
+	w.Restore(snap)
+	return nil
+}
+
+// upgradePlayer fills in what snapshots older than SnapshotVersion did not have
+func upgradePlayer(version int, p *Player) {
+	if version < 2 {
+		// units did not know their owner before version 2
+		for id, u := range p.Units {
+			u.Owner = p.Username
+			p.Units[id] = u
+		}
+	}
+}
+
+// writeSnapshot writes to a temporary file first so a crash while saving
+// never leaves a half written snapshot behind
+func writeSnapshot(path string, v any) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot directory: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file: %v", err)
+	}
+
+	if isGob(path) {
+		err = gob.NewEncoder(f).Encode(v)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("could not encode snapshot: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write snapshot file: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func readSnapshot(path string, v any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open snapshot file: %v", err)
+	}
+	defer f.Close()
+
+	if isGob(path) {
+		err = gob.NewDecoder(f).Decode(v)
+	} else {
+		err = json.NewDecoder(f).Decode(v)
+	}
+	if err != nil {
+		return fmt.Errorf("could not decode snapshot %v: %v", path, err)
+	}
+	return nil
+}
+
+func isGob(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gob")
+}
@@ -0,0 +1,320 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorldSnapshotRoundTrip(t *testing.T) {
+	for _, file := range []string{"world.json", "world.gob"} {
+		t.Run(file, func(t *testing.T) {
+			w := newTestWorld(t)
+			mustSpawn(t, w, "alice", "europe", RankInfantry)
+			mustSpawn(t, w, "alice", "asia", RankCavalry)
+			mustSpawn(t, w, "bob", "africa", RankArtillery)
+			w.SetPaused(true)
+
+			path := filepath.Join(t.TempDir(), file)
+			err := w.Save(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewWorld()
+			err = loaded.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"alice", "bob"} {
+				want, _ := w.Player(name)
+				got, ok := loaded.Player(name)
+				if !ok {
+					t.Fatalf("%v is missing after loading", name)
+				}
+				if len(got.Units) != len(want.Units) {
+					t.Fatalf("%v has %v units after loading, want %v", name, len(got.Units), len(want.Units))
+				}
+				for id, unit := range want.Units {
+					if got.Units[id] != unit {
+						t.Errorf("%v's unit %v is %+v after loading, want %+v", name, id, got.Units[id], unit)
+					}
+				}
+			}
+			if !loaded.Snapshot().Paused {
+				t.Error("the world is not paused after loading")
+			}
+		})
+	}
+}
+
+func TestWorldUnitIDsSurviveSnapshot(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// forget drops the saved sequences, like snapshots from before there were any
+		forget bool
+		// wantAlice is alice's next ID, without the sequence the dead unit's ID comes back
+		wantAlice int
+	}{
+		{"json", "world.json", false, 4},
+		{"gob", "world.gob", false, 4},
+		{"json without sequences", "world.json", true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			for i, want := range []int{1, 2, 3} {
+				unit := mustSpawn(t, w, "alice", "europe", RankInfantry)
+				if unit.ID != want {
+					t.Fatalf("spawn %v got ID %v, want %v", i+1, unit.ID, want)
+				}
+			}
+			// every player has their own sequence
+			if unit := mustSpawn(t, w, "bob", "asia", RankInfantry); unit.ID != 1 {
+				t.Fatalf("bob's first unit got ID %v, want 1", unit.ID)
+			}
+			// alice's last unit dies, its ID is not handed out again
+			w.mu.Lock()
+			delete(w.players["alice"].Units, 3)
+			w.mu.Unlock()
+
+			snap := w.Snapshot()
+			if tt.forget {
+				snap.NextUnitIDs = nil
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := writeSnapshot(path, snap)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := NewWorld()
+			err = loaded.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, _ := loaded.Player("alice"); len(p.Units) != 2 {
+				t.Fatalf("alice has %v units after loading, want 2", len(p.Units))
+			}
+			if unit := mustSpawn(t, loaded, "alice", "europe", RankInfantry); unit.ID != tt.wantAlice {
+				t.Errorf("alice's next unit got ID %v, want %v", unit.ID, tt.wantAlice)
+			}
+			if unit := mustSpawn(t, loaded, "bob", "asia", RankInfantry); unit.ID != 2 {
+				t.Errorf("bob's next unit got ID %v, want 2", unit.ID)
+			}
+		})
+	}
+}
+
+func TestGameStateUnitIDsSurviveSnapshot(t *testing.T) {
+	for _, file := range []string{"autosave.json", "autosave.gob"} {
+		t.Run(file, func(t *testing.T) {
+			gs := NewGameState("alice")
+			gs.HandleDelta(StateDelta{Username: "alice", Spawned: []Unit{
+				{ID: 1, Owner: "alice", Rank: RankInfantry, Location: "europe"},
+				{ID: 2, Owner: "alice", Rank: RankCavalry, Location: "asia"},
+			}})
+			// unit 2 died, the next unit is still 3
+			gs.removeUnits([]Unit{{ID: 2, Owner: "alice"}})
+
+			path := filepath.Join(t.TempDir(), file)
+			err := gs.Save(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewGameState("alice")
+			err = loaded.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := loaded.GetUnit(1); !ok {
+				t.Error("unit 1 is missing after loading")
+			}
+			if _, ok := loaded.GetUnit(2); ok {
+				t.Error("the dead unit 2 came back after loading")
+			}
+			loaded.mu.RLock()
+			next := loaded.nextUnitID
+			loaded.mu.RUnlock()
+			if next != 3 {
+				t.Errorf("next unit ID is %v after loading, want 3", next)
+			}
+		})
+	}
+}
+
+func TestLoadRefusesNewerSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.json")
+	err := writeSnapshot(path, WorldSnapshot{Version: SnapshotVersion + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewWorld().Load(path)
+	if err == nil {
+		t.Error("loaded a snapshot from a newer version")
+	}
+}
+
+func TestGameStateRefusesOtherPlayersSnapshot(t *testing.T) {
+	gs := NewGameState("alice")
+	err := gs.Restore(NewGameState("bob").Snapshot())
+	if err == nil {
+		t.Error("alice loaded bob's snapshot")
+	}
+}
+
+func TestDiplomacySurvivesSnapshot(t *testing.T) {
+	for _, file := range []string{"world.json", "world.gob"} {
+		t.Run(file, func(t *testing.T) {
+			w := newTestWorld(t)
+			for _, msg := range []DiplomacyMessage{
+				{Kind: DiplomacyProposeAlliance, From: "alice", To: "bob"},
+				{Kind: DiplomacyAcceptAlliance, From: "bob", To: "alice"},
+				{Kind: DiplomacyProposeTruce, From: "carol", To: "alice", Until: time.Now().Add(time.Hour)},
+			} {
+				_, err := w.ApplyDiplomacy(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), file)
+			err := w.Save(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewWorld()
+			err = loaded.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if loaded.diplomacy.Relation("alice", "bob", time.Now()) != RelationAlliance {
+				t.Error("alice and bob are at war after loading")
+			}
+			// the open proposal can still be accepted
+			changed, err := loaded.ApplyDiplomacy(DiplomacyMessage{Kind: DiplomacyAcceptTruce, From: "alice", To: "carol"})
+			if err != nil || !changed {
+				t.Errorf("accepting carol's truce after loading: changed = %v, err = %v", changed, err)
+			}
+		})
+	}
+}
+
+func TestDiplomacySnapshotInvolving(t *testing.T) {
+	now := time.Now()
+	d := NewDiplomacy()
+	for _, msg := range []DiplomacyMessage{
+		{Kind: DiplomacyProposeAlliance, From: "alice", To: "bob"},
+		{Kind: DiplomacyAcceptAlliance, From: "bob", To: "alice"},
+		{Kind: DiplomacyProposeAlliance, From: "carol", To: "dave"},
+		{Kind: DiplomacyProposeTruce, From: "carol", To: "alice", Until: now.Add(time.Hour)},
+	} {
+		_, err := d.Apply(msg, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := d.Snapshot(now).Involving("alice")
+	if len(snap.Treaties) != 1 || snap.Treaties[0].Players != [2]string{"alice", "bob"} {
+		t.Errorf("treaties = %+v, want only the alliance with bob", snap.Treaties)
+	}
+	if len(snap.Proposals) != 1 || snap.Proposals[0].From != "carol" {
+		t.Errorf("proposals = %+v, want only carol's truce", snap.Proposals)
+	}
+
+	restored := RestoreDiplomacy(snap)
+	if restored.Relation("alice", "bob", now) != RelationAlliance {
+		t.Error("the restored alliance is gone")
+	}
+	if restored.Relation("carol", "dave", now) != RelationWar {
+		t.Error("a treaty alice is not part of was restored")
+	}
+}
+
+func TestWorldPlayerSnapshot(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+	mustSpawn(t, w, "alice", "asia", RankCavalry)
+	_, err := w.ApplyDiplomacy(DiplomacyMessage{Kind: DiplomacyProposeAlliance, From: "bob", To: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.ApplyDiplomacy(DiplomacyMessage{Kind: DiplomacyProposeAlliance, From: "bob", To: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := w.PlayerSnapshot("alice")
+	if len(snap.Player.Units) != 2 || snap.NextUnitID != 3 {
+		t.Errorf("alice has %v units and next ID %v, want 2 and 3", len(snap.Player.Units), snap.NextUnitID)
+	}
+	if snap.Resources != w.Resources("alice") {
+		t.Errorf("resources = %v, want %v", snap.Resources, w.Resources("alice"))
+	}
+	if len(snap.Diplomacy.Proposals) != 1 || snap.Diplomacy.Proposals[0].To != "alice" {
+		t.Errorf("proposals = %+v, want only bob's proposal to alice", snap.Diplomacy.Proposals)
+	}
+
+	// a player who never spawned starts from nothing, and the client takes the state
+	snap = w.PlayerSnapshot("dave")
+	if len(snap.Player.Units) != 0 || snap.NextUnitID != 1 || snap.Resources != StartingResources {
+		t.Errorf("dave starts with %+v, want no units, ID 1 and the starting resources", snap)
+	}
+	gs := NewGameState("dave")
+	err = gs.Restore(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGameStateReconcile(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+	mustSpawn(t, w, "alice", "asia", RankCavalry)
+
+	// the saved snapshot is older than the world: unit 1 has moved since and unit 3 died
+	loaded := Snapshot{
+		Version: SnapshotVersion,
+		Player: Player{Username: "alice", Units: map[int]Unit{
+			1: {ID: 1, Owner: "alice", Rank: RankInfantry, Location: "africa"},
+			3: {ID: 3, Owner: "alice", Rank: RankArtillery, Location: "asia"},
+		}},
+		NextUnitID: 4,
+		Resources:  StartingResources,
+	}
+	gs := NewGameState("alice")
+	gone, err := gs.Reconcile(loaded, w.PlayerSnapshot("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gone) != 1 || gone[0].ID != 3 {
+		t.Errorf("gone = %v, want unit 3", gone)
+	}
+	snap := gs.Snapshot()
+	want, _ := w.Player("alice")
+	if len(snap.Player.Units) != len(want.Units) {
+		t.Fatalf("alice has %v units, want the server's %v", len(snap.Player.Units), len(want.Units))
+	}
+	for id, unit := range want.Units {
+		if snap.Player.Units[id] != unit {
+			t.Errorf("unit %v is %+v, want the server's %+v", id, snap.Player.Units[id], unit)
+		}
+	}
+	if snap.Resources != w.Resources("alice") || snap.NextUnitID != 3 {
+		t.Errorf("resources %v and next ID %v, want the server's %v and 3", snap.Resources, snap.NextUnitID, w.Resources("alice"))
+	}
+
+	_, err = gs.Reconcile(NewGameState("bob").Snapshot(), w.PlayerSnapshot("bob"))
+	if err == nil {
+		t.Error("alice reconciled bob's snapshot")
+	}
+	if units := gs.Snapshot().Player.Units; len(units) != 2 {
+		t.Errorf("alice has %v units after the refused reconcile, want 2", len(units))
+	}
+}
@@ -3,47 +3,47 @@ package gamelogic
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
-var PossibleUnits = map[string]struct{}{
-	"infantry":  {},
-	"cavalry":   {},
-	"artillery": {},
-}
-
-var PossibleLocations = map[string]struct{}{
-	"americas":   {},
-	"europe":     {},
-	"africa":     {},
-	"asia":       {},
-	"antarctica": {},
-	"australia":  {},
-}
-
-func (gs *GameState) CommandSpawn(words []string) error {
+// CommandSpawn only builds the request, the unit exists once the server sends it back
+func (gs *GameState) CommandSpawn(words []string) (CommandRequest, error) {
 	if len(words) < 3 { //idk why author put it here if I need to check the possible words anyway
-		return errors.New("usage: spawn <location> <rank>")
+		return CommandRequest{}, errors.New("usage: spawn <location> <rank>")
 	}
-
-	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	err := gs.inMatch()
+	if err != nil {
+		return CommandRequest{}, err
 	}
 
-	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	location := Location(words[1])
+	rank := UnitRank(words[2])
+	err = validateSpawn(gs.Map(), gs.Catalog(), location, rank)
+	if err != nil {
+		return CommandRequest{}, err
 	}
+	stats, _ := gs.Catalog().Stats(rank)
+	if stats.Cost > gs.Resources() {
+		return CommandRequest{}, fmt.Errorf("error: not enough resources, you have %v and a(n) %v costs %v", gs.Resources(), rank, stats.Cost)
+	}
+
+	return CommandRequest{
+		Kind:        CommandKindSpawn,
+		Username:    gs.GetUsername(),
+		Location:    location,
+		Rank:        rank,
+		Turn:        gs.Turn(),
+		RequestedAt: time.Now(),
+	}, nil
+}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
+func validateSpawn(m *Map, c *Catalog, location Location, rank UnitRank) error {
+	if !m.Has(location) {
+		return fmt.Errorf("error: %s is not a valid location", location)
+	}
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
+	if _, ok := c.Stats(rank); !ok {
+		return fmt.Errorf("error: %s is not a valid unit", rank)
+	}
 	return nil
 }
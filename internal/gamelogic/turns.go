@@ -0,0 +1,240 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// TurnResult is everything that happened when a turn was resolved
+type TurnResult struct {
+	Turn     int
+	Spawned  map[string][]Unit
+	Moves    []AcceptedMove
+	Wars     []RecognitionOfWar
+	Rejected map[string][]string
+	// Income is what every player earned at the end of the turn
+	Income map[string]int
+}
+
+// StartTurn switches the world to turn mode, or moves it on to the next turn.
+// Orders are only accepted for the turn that was started last.
+func (w *World) StartTurn() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turn++
+	w.orders = nil
+	return w.turn
+}
+
+// StopTurns goes back to real time, orders that were not resolved yet are dropped
+func (w *World) StopTurns() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turn = 0
+	w.orders = nil
+}
+
+// Turn is the current turn, 0 when the game is played in real time
+func (w *World) Turn() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.turn
+}
+
+// QueueOrder checks the order against the world as it is now and keeps it for ResolveTurn
+func (w *World) QueueOrder(cmd CommandRequest) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn == 0 {
+		return errors.New("the game is not played in turns")
+	}
+	if cmd.Turn != w.turn {
+		return fmt.Errorf("the order is for turn %v, it is turn %v", cmd.Turn, w.turn)
+	}
+	if w.paused {
+		return errors.New("the game is paused, you can not give orders")
+	}
+	err := w.playing()
+	if err != nil {
+		return err
+	}
+
+	switch cmd.Kind {
+	case CommandKindSpawn:
+		err := validateSpawn(w.gameMap, w.catalog, cmd.Location, cmd.Rank)
+		if err != nil {
+			return err
+		}
+		// the spawns already ordered this turn are paid for at the end of it
+		cost := 0
+		for _, order := range append(w.orders, cmd) {
+			if order.Kind == CommandKindSpawn && order.Username == cmd.Username {
+				stats, _ := w.catalog.Stats(order.Rank)
+				cost += stats.Cost
+			}
+		}
+		resources := w.resourcesOf(cmd.Username)
+		if cost > resources {
+			return fmt.Errorf("error: not enough resources, you have %v and your spawns this turn cost %v", resources, cost)
+		}
+	case CommandKindMove:
+		err := w.validateMove(cmd.Username, cmd.Location, cmd.UnitIDs)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Kind)
+	}
+	w.orders = append(w.orders, cmd)
+	return nil
+}
+
+// ResolveTurn carries out every order of the turn at once: first the spawns, then every
+// move, and only then the wars, so no player gets to move first. A unit only follows
+// the first order that moves it, and each location is fought over once however many
+// moves went there. Income is paid once the wars are over.
+func (w *World) ResolveTurn() TurnResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := TurnResult{
+		Turn:     w.turn,
+		Spawned:  map[string][]Unit{},
+		Rejected: map[string][]string{},
+	}
+	reject := func(cmd CommandRequest, err error) {
+		result.Rejected[cmd.Username] = append(result.Rejected[cmd.Username], err.Error())
+	}
+	// the match can end while orders wait for the deadline
+	err := w.playing()
+	if err != nil {
+		for _, cmd := range w.orders {
+			reject(cmd, err)
+		}
+		w.orders = nil
+		return result
+	}
+
+	for _, cmd := range w.orders {
+		if cmd.Kind != CommandKindSpawn {
+			continue
+		}
+		unit, err := w.spawn(cmd.Username, cmd.Location, cmd.Rank)
+		if err != nil {
+			reject(cmd, err)
+			continue
+		}
+		result.Spawned[cmd.Username] = append(result.Spawned[cmd.Username], unit)
+	}
+
+	moved := map[string]bool{}
+	for _, cmd := range w.orders {
+		if cmd.Kind != CommandKindMove {
+			continue
+		}
+		ids := []int{}
+		for _, id := range cmd.UnitIDs {
+			if !moved[UnitKey(cmd.Username, id)] {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			reject(cmd, fmt.Errorf("error: units %v already moved this turn", cmd.UnitIDs))
+			continue
+		}
+		move, err := w.move(cmd.Username, cmd.Location, ids)
+		if err != nil {
+			reject(cmd, err)
+			continue
+		}
+		for _, id := range ids {
+			moved[UnitKey(cmd.Username, id)] = true
+		}
+		result.Moves = append(result.Moves, move)
+	}
+
+	// every location units moved into is fought over once, by everyone who moved there
+	contested := []Location{}
+	attackers := map[Location][]string{}
+	for _, move := range result.Moves {
+		username := move.Player.Username
+		if _, ok := attackers[move.ToLocation]; !ok {
+			contested = append(contested, move.ToLocation)
+		}
+		if !slices.Contains(attackers[move.ToLocation], username) {
+			attackers[move.ToLocation] = append(attackers[move.ToLocation], username)
+		}
+	}
+	for _, location := range contested {
+		result.Wars = append(result.Wars, w.battle(location, attackers[location])...)
+	}
+
+	result.Income = w.collectIncome()
+
+	w.orders = nil
+	return result
+}
+
+// Turn is the turn orders are given for, 0 when the game is played in real time
+func (gs *GameState) Turn() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.turn.Turn
+}
+
+func (gs *GameState) HandleTurn(ts routing.TurnState) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+
+	gs.mu.Lock()
+	if ts.Phase == routing.TurnPhaseOff {
+		gs.turn = routing.TurnState{}
+	} else {
+		gs.turn = ts
+	}
+	gs.orders = nil
+	gs.mu.Unlock()
+
+	switch ts.Phase {
+	case routing.TurnPhaseOrders:
+		fmt.Printf("==== Turn %v ====\n", ts.Turn)
+		fmt.Printf("Send your orders, the turn ends in %v\n", time.Until(ts.Deadline).Round(time.Second))
+	case routing.TurnPhaseResolved:
+		fmt.Printf("==== Turn %v is over ====\n", ts.Turn)
+	case routing.TurnPhaseOff:
+		fmt.Println("==== Turns are over, the game is played in real time ====")
+	}
+}
+
+// queueOrder keeps the orders the server accepted for the turn so the player can look at them
+func (gs *GameState) queueOrder(cmd CommandRequest) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if cmd.Turn != gs.turn.Turn {
+		return
+	}
+	gs.orders = append(gs.orders, cmd)
+}
+
+func (gs *GameState) CommandOrders() {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if gs.turn.Turn == 0 {
+		fmt.Println("The game is played in real time, orders are carried out at once.")
+		return
+	}
+
+	fmt.Printf("Turn %v ends in %v, your orders:\n", gs.turn.Turn, time.Until(gs.turn.Deadline).Round(time.Second))
+	for _, cmd := range gs.orders {
+		switch cmd.Kind {
+		case CommandKindSpawn:
+			fmt.Printf("* spawn a(n) %v in %v\n", cmd.Rank, cmd.Location)
+		case CommandKindMove:
+			fmt.Printf("* move %v to %v\n", cmd.UnitIDs, cmd.Location)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package gamelogic
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func spawnOrder(username string, location Location, rank UnitRank) CommandRequest {
+	return CommandRequest{Kind: CommandKindSpawn, Username: username, Location: location, Rank: rank}
+}
+
+func moveOrder(username string, location Location, ids ...int) CommandRequest {
+	return CommandRequest{Kind: CommandKindMove, Username: username, Location: location, UnitIDs: ids}
+}
+
+// infantry spawns count infantry for the player in the location
+func infantry(username string, location Location, count int) []CommandRequest {
+	cmds := []CommandRequest{}
+	for i := 0; i < count; i++ {
+		cmds = append(cmds, spawnOrder(username, location, RankInfantry))
+	}
+	return cmds
+}
+
+func TestResolveTurn(t *testing.T) {
+	tests := []struct {
+		name   string
+		combat CombatMode
+		// units are spawned in real time before the turn starts
+		units  []CommandRequest
+		orders []CommandRequest
+		// where every player's units are once the turn is resolved
+		want     map[string][]Location
+		moves    int
+		wars     int
+		rejected map[string]int
+	}{
+		{
+			name:   "spawns come before moves",
+			units:  []CommandRequest{spawnOrder("alice", "europe", RankInfantry)},
+			orders: []CommandRequest{moveOrder("alice", "asia", 1), spawnOrder("bob", "asia", RankArtillery)},
+			want:   map[string][]Location{"alice": {}, "bob": {"asia"}},
+			moves:  1,
+			wars:   1,
+		},
+		{
+			name: "every move comes before the wars",
+			units: []CommandRequest{
+				spawnOrder("alice", "americas", RankInfantry),
+				spawnOrder("bob", "europe", RankInfantry),
+			},
+			orders: []CommandRequest{moveOrder("alice", "europe", 1), moveOrder("bob", "asia", 1)},
+			want:   map[string][]Location{"alice": {"europe"}, "bob": {"asia"}},
+			moves:  2,
+			wars:   0,
+		},
+		{
+			name:     "the first order that moves a unit wins",
+			units:    []CommandRequest{spawnOrder("alice", "europe", RankInfantry)},
+			orders:   []CommandRequest{moveOrder("alice", "asia", 1), moveOrder("alice", "africa", 1)},
+			want:     map[string][]Location{"alice": {"asia"}},
+			moves:    1,
+			rejected: map[string]int{"alice": 1},
+		},
+		{
+			name: "a location is fought over once",
+			units: []CommandRequest{
+				spawnOrder("alice", "europe", RankArtillery),
+				spawnOrder("bob", "asia", RankCavalry),
+			},
+			orders: []CommandRequest{moveOrder("alice", "africa", 1), moveOrder("bob", "africa", 1)},
+			want:   map[string][]Location{"alice": {"africa"}, "bob": {}},
+			moves:  2,
+			wars:   1,
+		},
+		{
+			// dice leave both sides standing, they still only fight once
+			name:   "a location is fought over once with dice",
+			combat: CombatDice,
+			units:  append(infantry("alice", "europe", 10), infantry("bob", "asia", 10)...),
+			orders: []CommandRequest{
+				moveOrder("alice", "africa", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+				moveOrder("bob", "africa", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+			},
+			moves: 2,
+			wars:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			w.SetCombatMode(tt.combat)
+			for _, cmd := range tt.units {
+				mustSpawn(t, w, cmd.Username, cmd.Location, cmd.Rank)
+			}
+
+			turn := w.StartTurn()
+			for _, cmd := range tt.orders {
+				cmd.Turn = turn
+				err := w.QueueOrder(cmd)
+				if err != nil {
+					t.Fatalf("order %+v was refused: %v", cmd, err)
+				}
+			}
+			result := w.ResolveTurn()
+
+			if result.Turn != turn {
+				t.Errorf("resolved turn %v, want %v", result.Turn, turn)
+			}
+			if len(result.Moves) != tt.moves {
+				t.Errorf("%v moves, want %v", len(result.Moves), tt.moves)
+			}
+			if len(result.Wars) != tt.wars {
+				t.Errorf("%v wars, want %v", len(result.Wars), tt.wars)
+			}
+			for username, want := range tt.rejected {
+				if got := len(result.Rejected[username]); got != want {
+					t.Errorf("%v had %v orders rejected, want %v: %v", username, got, want, result.Rejected[username])
+				}
+			}
+			for username, want := range tt.want {
+				p, _ := w.Player(username)
+				got := []Location{}
+				for _, unit := range p.Units {
+					got = append(got, unit.Location)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, want) {
+					t.Errorf("%v has units in %v, want %v", username, got, want)
+				}
+			}
+			if w.orders != nil {
+				t.Errorf("%v orders are left after the turn", len(w.orders))
+			}
+		})
+	}
+}
+
+func TestQueueOrderRefuses(t *testing.T) {
+	tests := []struct {
+		name  string
+		turns bool
+		cmd   CommandRequest
+		err   string
+	}{
+		{"real time", false, spawnOrder("alice", "europe", RankInfantry), "not played in turns"},
+		{"another turn", true, CommandRequest{Kind: CommandKindSpawn, Username: "alice", Location: "europe", Rank: RankInfantry, Turn: 7}, "it is turn 1"},
+		{"unknown command", true, CommandRequest{Kind: "fly", Username: "alice", Turn: 1}, "unknown command"},
+		{"move without units", true, CommandRequest{Kind: CommandKindMove, Username: "alice", Location: "asia", UnitIDs: []int{1}, Turn: 1}, "spawn some first"},
+		{"unknown rank", true, CommandRequest{Kind: CommandKindSpawn, Username: "alice", Location: "europe", Rank: "dragon", Turn: 1}, "not a valid unit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			if tt.turns {
+				w.StartTurn()
+			}
+			err := w.QueueOrder(tt.cmd)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.err)
+			}
+			if _, ok := w.Player(tt.cmd.Username); ok {
+				t.Error("a refused order made the player part of the world")
+			}
+		})
+	}
+}
+
+func TestQueueOrderCountsTheTurnsSpawns(t *testing.T) {
+	w := newTestWorld(t)
+	turn := w.StartTurn()
+	for i := 0; i < 2; i++ {
+		cmd := spawnOrder("alice", "europe", RankArtillery)
+		cmd.Turn = turn
+		err := w.QueueOrder(cmd)
+		if err != nil {
+			t.Fatalf("spawn %v was refused: %v", i+1, err)
+		}
+	}
+	cmd := spawnOrder("alice", "europe", RankInfantry)
+	cmd.Turn = turn
+	err := w.QueueOrder(cmd)
+	if err == nil {
+		t.Error("a third spawn was queued with every resource already spent this turn")
+	}
+}
@@ -0,0 +1,47 @@
+package gamelogic
+
+// Visible is what the player can see: every location they have units in and the locations next to those
+func (m *Map) Visible(p Player) map[Location]bool {
+	visible := map[Location]bool{}
+	for _, unit := range p.Units {
+		visible[unit.Location] = true
+		for _, n := range m.Neighbors(unit.Location) {
+			visible[n] = true
+		}
+	}
+	return visible
+}
+
+// Observers are the other players who can see units arrive in the location
+func (w *World) Observers(username string, location Location) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.observers(username, location)
+}
+
+// observers is called with the lock held
+func (w *World) observers(username string, location Location) []string {
+	observers := []string{}
+	for _, name := range w.usernames() {
+		if name == username {
+			continue
+		}
+		if w.gameMap.Visible(*w.players[name])[location] {
+			observers = append(observers, name)
+		}
+	}
+	return observers
+}
+
+// RedactMove leaves only what an observer sees: the units that moved, not the rest of the army
+func RedactMove(move ArmyMove) ArmyMove {
+	units := map[int]Unit{}
+	for _, unit := range move.Units {
+		units[unit.ID] = unit
+	}
+	return ArmyMove{
+		Player:     Player{Username: move.Player.Username, Units: units},
+		Units:      append([]Unit{}, move.Units...),
+		ToLocation: move.ToLocation,
+	}
+}
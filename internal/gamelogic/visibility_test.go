@@ -0,0 +1,146 @@
+package gamelogic
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestVisible(t *testing.T) {
+	tests := []struct {
+		name      string
+		locations []Location
+		want      []Location
+	}{
+		{"no units", nil, []Location{}},
+		{"one location and its neighbors", []Location{"australia"}, []Location{"antarctica", "asia", "australia"}},
+		{"locations overlap", []Location{"europe", "africa"}, []Location{"africa", "americas", "antarctica", "asia", "europe"}},
+	}
+
+	m := DefaultMap()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Player{Username: "alice", Units: map[int]Unit{}}
+			for i, location := range tt.locations {
+				p.Units[i+1] = Unit{ID: i + 1, Owner: "alice", Rank: RankInfantry, Location: location}
+			}
+			got := []Location{}
+			for location, ok := range m.Visible(p) {
+				if ok {
+					got = append(got, location)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("visible = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObservers(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+	mustSpawn(t, w, "bob", "asia", RankInfantry)
+	mustSpawn(t, w, "carol", "antarctica", RankInfantry)
+
+	tests := []struct {
+		location Location
+		want     []string
+	}{
+		{"europe", []string{"bob"}},
+		{"australia", []string{"bob", "carol"}},
+		{"americas", []string{"bob", "carol"}},
+		{"antarctica", []string{"carol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.location), func(t *testing.T) {
+			// the player who moved is never an observer of their own move
+			got := w.Observers("alice", tt.location)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("observers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactMove(t *testing.T) {
+	moved := Unit{ID: 2, Owner: "alice", Rank: RankCavalry, Location: "asia"}
+	move := ArmyMove{
+		Player: Player{Username: "alice", Units: map[int]Unit{
+			1: {ID: 1, Owner: "alice", Rank: RankArtillery, Location: "europe"},
+			2: moved,
+		}},
+		Units:      []Unit{moved},
+		ToLocation: "asia",
+	}
+
+	redacted := RedactMove(move)
+	if redacted.Player.Username != "alice" || redacted.ToLocation != "asia" {
+		t.Errorf("redacted = %+v, want alice's move to asia", redacted)
+	}
+	if len(redacted.Player.Units) != 1 || redacted.Player.Units[2] != moved {
+		t.Errorf("the observer sees %v, want only the unit that moved", redacted.Player.Units)
+	}
+	if len(redacted.Units) != 1 || redacted.Units[0] != moved {
+		t.Errorf("units = %v, want the unit that moved", redacted.Units)
+	}
+
+	// the redacted move does not share the original's units
+	redacted.Units[0].Location = "africa"
+	if move.Units[0].Location != "asia" {
+		t.Error("changing the redacted move changed the original")
+	}
+}
+
+func TestMoveObserversBeforeWar(t *testing.T) {
+	tests := []struct {
+		name string
+		move func(w *World) AcceptedMove
+	}{
+		{"real time", func(w *World) AcceptedMove {
+			move, wars, err := w.Move("alice", "asia", []int{1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(wars) != 1 {
+				t.Fatalf("%v wars, want 1", len(wars))
+			}
+			return move
+		}},
+		{"turns", func(w *World) AcceptedMove {
+			turn := w.StartTurn()
+			order := moveOrder("alice", "asia", 1)
+			order.Turn = turn
+			err := w.QueueOrder(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := w.ResolveTurn()
+			if len(result.Moves) != 1 || len(result.Wars) != 1 {
+				t.Fatalf("%v moves and %v wars, want 1 and 1", len(result.Moves), len(result.Wars))
+			}
+			return result.Moves[0]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// bob's only unit sees the move arrive and dies in the war it starts
+			w := newTestWorld(t)
+			mustSpawn(t, w, "alice", "europe", RankArtillery)
+			mustSpawn(t, w, "bob", "asia", RankInfantry)
+
+			move := tt.move(w)
+			if !slices.Equal(move.Observers, []string{"bob"}) {
+				t.Errorf("observers = %v, want bob", move.Observers)
+			}
+			if got := w.Observers("alice", "asia"); len(got) != 0 {
+				t.Errorf("observers after the war = %v, want nobody", got)
+			}
+			if len(move.Units) != 1 || move.Units[0].Location != "asia" {
+				t.Errorf("units = %v, want the artillery in asia", move.Units)
+			}
+		})
+	}
+}
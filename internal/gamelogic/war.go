@@ -2,6 +2,8 @@ package gamelogic
 
 import (
 	"fmt"
+	"math/rand"
+	"sort"
 )
 
 type WarOutcome int
@@ -14,6 +16,171 @@ const (
 	WarOutcomeDraw
 )
 
+const (
+	diceRounds = 3
+	diceSides  = 6
+)
+
+// WarResult is how a war ends. The server and both clients get the same result
+// from the same RecognitionOfWar, so only the server's copy changes the world.
+type WarResult struct {
+	Location      Location
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+	AttackerPower int
+	DefenderPower int
+	Winner        string
+	Loser         string
+	Draw          bool
+	// Casualties are the units of either side that died
+	Casualties []Unit
+	// Report is only set for dice combat
+	Report *BattleReport
+}
+
+// BattleReport is every round of a dice war
+type BattleReport struct {
+	Seed   int64
+	Rounds []BattleRound
+}
+
+type BattleRound struct {
+	AttackerRolls  []int
+	DefenderRolls  []int
+	AttackerLosses []Unit
+	DefenderLosses []Unit
+}
+
+func ResolveWar(rw RecognitionOfWar, catalog *Catalog) (WarResult, bool) {
+	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
+	if overlappingLocation == "" {
+		return WarResult{}, false
+	}
+
+	result := WarResult{
+		Location:      overlappingLocation,
+		AttackerUnits: unitsAt(rw.Attacker, overlappingLocation),
+		DefenderUnits: unitsAt(rw.Defender, overlappingLocation),
+	}
+	result.AttackerPower = catalog.Power(result.AttackerUnits, overlappingLocation)
+	result.DefenderPower = catalog.Power(result.DefenderUnits, overlappingLocation)
+
+	if rw.Mode == CombatDice {
+		resolveDice(rw, catalog, &result)
+		return result, true
+	}
+
+	switch {
+	case result.AttackerPower > result.DefenderPower:
+		result.Winner, result.Loser = rw.Attacker.Username, rw.Defender.Username
+		result.Casualties = result.DefenderUnits
+	case result.DefenderPower > result.AttackerPower:
+		result.Winner, result.Loser = rw.Defender.Username, rw.Attacker.Username
+		result.Casualties = result.AttackerUnits
+	default:
+		result.Winner, result.Loser = rw.Attacker.Username, rw.Defender.Username
+		result.Draw = true
+		result.Casualties = append(append([]Unit{}, result.AttackerUnits...), result.DefenderUnits...)
+	}
+	return result, true
+}
+
+// resolveDice fights up to diceRounds rounds. Every unit rolls a die each round and hits
+// when the roll is at most its hit chance, every hit kills the weakest enemy unit left.
+// When both sides still stand after the last round the one with more power left wins.
+func resolveDice(rw RecognitionOfWar, catalog *Catalog, result *WarResult) {
+	rng := rand.New(rand.NewSource(rw.Seed))
+	report := &BattleReport{Seed: rw.Seed}
+
+	attackers := weakestFirst(result.AttackerUnits, catalog, result.Location)
+	defenders := weakestFirst(result.DefenderUnits, catalog, result.Location)
+	for i := 0; i < diceRounds && len(attackers) > 0 && len(defenders) > 0; i++ {
+		round := BattleRound{}
+		attackerHits := 0
+		for _, unit := range attackers {
+			roll := rng.Intn(diceSides) + 1
+			round.AttackerRolls = append(round.AttackerRolls, roll)
+			if roll <= hitChance(catalog, unit, result.Location) {
+				attackerHits++
+			}
+		}
+		defenderHits := 0
+		for _, unit := range defenders {
+			roll := rng.Intn(diceSides) + 1
+			round.DefenderRolls = append(round.DefenderRolls, roll)
+			if roll <= hitChance(catalog, unit, result.Location) {
+				defenderHits++
+			}
+		}
+
+		// both sides fire before anyone is removed
+		round.DefenderLosses = defenders[:min(attackerHits, len(defenders))]
+		defenders = defenders[len(round.DefenderLosses):]
+		round.AttackerLosses = attackers[:min(defenderHits, len(attackers))]
+		attackers = attackers[len(round.AttackerLosses):]
+
+		result.Casualties = append(result.Casualties, round.AttackerLosses...)
+		result.Casualties = append(result.Casualties, round.DefenderLosses...)
+		report.Rounds = append(report.Rounds, round)
+	}
+	result.Report = report
+
+	attackerLeft := catalog.Power(attackers, result.Location)
+	defenderLeft := catalog.Power(defenders, result.Location)
+	switch {
+	case attackerLeft > defenderLeft:
+		result.Winner, result.Loser = rw.Attacker.Username, rw.Defender.Username
+	case defenderLeft > attackerLeft:
+		result.Winner, result.Loser = rw.Defender.Username, rw.Attacker.Username
+	default:
+		result.Winner, result.Loser = rw.Attacker.Username, rw.Defender.Username
+		result.Draw = true
+	}
+}
+
+// hitChance grows with power: infantry hits on a 1, cavalry up to a 2 and artillery up to a 4
+func hitChance(catalog *Catalog, unit Unit, location Location) int {
+	chance := 1 + catalog.Power([]Unit{unit}, location)/3
+	return max(1, min(chance, diceSides-1))
+}
+
+// unitsAt sorts by ID, map order is random and the dice have to be rolled in the same order everywhere
+func unitsAt(p Player, location Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == location {
+			unit.Owner = p.Username
+			units = append(units, unit)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units
+}
+
+func weakestFirst(units []Unit, catalog *Catalog, location Location) []Unit {
+	sorted := append([]Unit{}, units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return catalog.Power(sorted[i:i+1], location) < catalog.Power(sorted[j:j+1], location)
+	})
+	return sorted
+}
+
+// Lost reports whether username lost the war, with dice it may still have units left
+func (r WarResult) Lost(username string) bool {
+	return r.Draw || r.Loser == username
+}
+
+// CasualtiesOf are the units username lost
+func (r WarResult) CasualtiesOf(username string) []Unit {
+	units := []Unit{}
+	for _, unit := range r.Casualties {
+		if unit.Owner == username {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
 func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
@@ -22,84 +189,59 @@ func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner
 
 	player := gs.GetPlayerSnap()
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
-	}
-
-	if player.Username != rw.Attacker.Username {
+	if player.Username != rw.Attacker.Username && player.Username != rw.Defender.Username {
 		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
 		return WarOutcomeNotInvolved, "", ""
 	}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
+	result, ok := ResolveWar(rw, gs.Catalog())
+	if !ok {
 		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
 		return WarOutcomeNoUnits, "", ""
 	}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
+	fmt.Printf("%s's units:\n", rw.Attacker.Username)
+	for _, unit := range result.AttackerUnits {
+		fmt.Printf("  * %v (%v)\n", unit.Rank, unit.Key())
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
-		}
+	fmt.Printf("%s's units:\n", rw.Defender.Username)
+	for _, unit := range result.DefenderUnits {
+		fmt.Printf("  * %v (%v)\n", unit.Rank, unit.Key())
+	}
+	fmt.Printf("Attacker has a power level of %v\n", result.AttackerPower)
+	fmt.Printf("Defender has a power level of %v\n", result.DefenderPower)
+	if result.Report != nil {
+		printBattleReport(*result.Report)
 	}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	lost := result.CasualtiesOf(player.Username)
+	gs.removeUnits(lost)
+	if len(lost) > 0 {
+		fmt.Printf("You lost %v unit(s) in %s.\n", len(lost), result.Location)
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+
+	if result.Draw {
+		fmt.Println("The war ended in a draw!")
+		return WarOutcomeDraw, result.Winner, result.Loser
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+
+	fmt.Printf("%s has won the war!\n", result.Winner)
+	if result.Lost(player.Username) {
+		fmt.Println("You have lost the war!")
+		return WarOutcomeOpponentWon, result.Winner, result.Loser
+	}
+	return WarOutcomeYouWon, result.Winner, result.Loser
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
-		}
-		if unit.Rank == RankCavalry {
-			power += 5
+func printBattleReport(report BattleReport) {
+	fmt.Printf("The dice were rolled with seed %v\n", report.Seed)
+	for i, round := range report.Rounds {
+		fmt.Printf("Round %v: attacker rolled %v, defender rolled %v\n", i+1, round.AttackerRolls, round.DefenderRolls)
+		for _, unit := range round.AttackerLosses {
+			fmt.Printf("  * attacker lost %v (%v)\n", unit.Rank, unit.Key())
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+		for _, unit := range round.DefenderLosses {
+			fmt.Printf("  * defender lost %v (%v)\n", unit.Rank, unit.Key())
 		}
 	}
-	return power
 }
@@ -0,0 +1,148 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func army(username string, location Location, ranks ...UnitRank) Player {
+	p := Player{Username: username, Units: map[int]Unit{}}
+	for i, rank := range ranks {
+		p.Units[i+1] = Unit{ID: i + 1, Owner: username, Rank: rank, Location: location}
+	}
+	return p
+}
+
+func TestResolveDiceIsTheSameForTheSameSeed(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     int64
+		attacker []UnitRank
+		defender []UnitRank
+	}{
+		{"one on one", 1, []UnitRank{RankInfantry}, []UnitRank{RankInfantry}},
+		{"mixed stacks", 42, []UnitRank{RankInfantry, RankCavalry, RankArtillery}, []UnitRank{RankCavalry, RankCavalry}},
+		{"many infantry", 7, []UnitRank{RankInfantry, RankInfantry, RankInfantry, RankInfantry}, []UnitRank{RankArtillery}},
+		{"negative seed", -12345, []UnitRank{RankArtillery, RankArtillery}, []UnitRank{RankInfantry, RankCavalry, RankArtillery}},
+	}
+
+	catalog := DefaultCatalog()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := RecognitionOfWar{
+				Attacker: army("alice", "asia", tt.attacker...),
+				Defender: army("bob", "asia", tt.defender...),
+				Mode:     CombatDice,
+				Seed:     tt.seed,
+			}
+			want, ok := ResolveWar(rw, catalog)
+			if !ok {
+				t.Fatal("no war was fought")
+			}
+			if want.Report == nil || want.Report.Seed != tt.seed {
+				t.Fatalf("report = %+v, want one rolled with seed %v", want.Report, tt.seed)
+			}
+
+			// the clients resolve the war they got from the broker
+			data, err := json.Marshal(rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				received := RecognitionOfWar{}
+				err = json.Unmarshal(data, &received)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := ResolveWar(received, catalog)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("resolve %v got %+v, want %+v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveDiceCasualties(t *testing.T) {
+	catalog := DefaultCatalog()
+	for seed := int64(0); seed < 50; seed++ {
+		rw := RecognitionOfWar{
+			Attacker: army("alice", "asia", RankInfantry, RankCavalry, RankArtillery),
+			Defender: army("bob", "asia", RankInfantry, RankInfantry, RankCavalry),
+			Mode:     CombatDice,
+			Seed:     seed,
+		}
+		result, _ := ResolveWar(rw, catalog)
+
+		if len(result.Report.Rounds) > diceRounds {
+			t.Fatalf("seed %v fought %v rounds, at most %v", seed, len(result.Report.Rounds), diceRounds)
+		}
+		seen := map[string]bool{}
+		for _, unit := range result.Casualties {
+			if seen[unit.Key()] {
+				t.Fatalf("seed %v killed %v twice", seed, unit.Key())
+			}
+			seen[unit.Key()] = true
+		}
+		if len(result.CasualtiesOf("alice"))+len(result.CasualtiesOf("bob")) != len(result.Casualties) {
+			t.Fatalf("seed %v has casualties that belong to nobody: %v", seed, result.Casualties)
+		}
+		// every hit kills the weakest unit left, so artillery is the last to go
+		for _, round := range result.Report.Rounds {
+			for i := 1; i < len(round.AttackerLosses); i++ {
+				if catalog.Power(round.AttackerLosses[i:i+1], "asia") < catalog.Power(round.AttackerLosses[i-1:i], "asia") {
+					t.Fatalf("seed %v killed %v before %v", seed, round.AttackerLosses[i-1].Rank, round.AttackerLosses[i].Rank)
+				}
+			}
+		}
+	}
+}
+
+func TestResolveWarByPower(t *testing.T) {
+	tests := []struct {
+		name     string
+		attacker []UnitRank
+		defender []UnitRank
+		winner   string
+		draw     bool
+		// the loser, or both sides on a draw, lose every unit there
+		casualties int
+	}{
+		{"attacker has more power", []UnitRank{RankArtillery}, []UnitRank{RankCavalry, RankInfantry}, "alice", false, 2},
+		{"defender has more power", []UnitRank{RankInfantry}, []UnitRank{RankCavalry}, "bob", false, 1},
+		{"same power", []UnitRank{RankCavalry}, []UnitRank{RankInfantry, RankInfantry, RankInfantry, RankInfantry, RankInfantry}, "alice", true, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := RecognitionOfWar{
+				Attacker: army("alice", "asia", tt.attacker...),
+				Defender: army("bob", "asia", tt.defender...),
+			}
+			result, ok := ResolveWar(rw, DefaultCatalog())
+			if !ok {
+				t.Fatal("no war was fought")
+			}
+			if result.Winner != tt.winner || result.Draw != tt.draw {
+				t.Errorf("winner = %v, draw = %v, want %v, %v", result.Winner, result.Draw, tt.winner, tt.draw)
+			}
+			if len(result.Casualties) != tt.casualties {
+				t.Errorf("%v casualties, want %v", len(result.Casualties), tt.casualties)
+			}
+		})
+	}
+}
+
+func TestResolveWarNeedsTheSameLocation(t *testing.T) {
+	rw := RecognitionOfWar{
+		Attacker: army("alice", "asia", RankInfantry),
+		Defender: army("bob", "europe", RankInfantry),
+		Mode:     CombatDice,
+		Seed:     1,
+	}
+	_, ok := ResolveWar(rw, DefaultCatalog())
+	if ok {
+		t.Error("a war was fought between different locations")
+	}
+}
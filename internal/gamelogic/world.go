@@ -0,0 +1,360 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// World is the server's copy of every player's units. Clients only ask for changes,
+// the world decides whether they happen.
+type World struct {
+	players map[string]*Player
+	// nextUnitIDs is each player's ID sequence, it only goes up so the IDs of
+	// dead units are never reused
+	nextUnitIDs map[string]int
+	resources   map[string]int
+	paused      bool
+	events      EventLog
+	gameMap     *Map
+	catalog     *Catalog
+	combat      CombatMode
+	// turn is 0 when the game is played in real time, otherwise orders
+	// are kept until the turn is resolved
+	turn      int
+	orders    []CommandRequest
+	diplomacy *Diplomacy
+	match     routing.MatchState
+	victory   VictoryCondition
+	mu        *sync.RWMutex
+}
+
+func NewWorld() *World {
+	return &World{
+		players:     map[string]*Player{},
+		nextUnitIDs: map[string]int{},
+		resources:   map[string]int{},
+		gameMap:     DefaultMap(),
+		catalog:     DefaultCatalog(),
+		diplomacy:   NewDiplomacy(),
+		match:       routing.MatchState{Phase: routing.MatchPhaseLobby, Victory: DefaultVictory().String()},
+		victory:     DefaultVictory(),
+		mu:          &sync.RWMutex{},
+	}
+}
+
+func (w *World) SetMap(m *Map) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gameMap = m
+}
+
+func (w *World) Map() *Map {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.gameMap
+}
+
+func (w *World) SetCatalog(c *Catalog) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.catalog = c
+}
+
+// SetCombatMode decides how the wars started from now on are fought
+func (w *World) SetCombatMode(mode CombatMode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.combat = mode
+}
+
+func (w *World) Catalog() *Catalog {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.catalog
+}
+
+// RecordEvents appends every change the world makes to l from now on
+func (w *World) RecordEvents(l EventLog) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = l
+}
+
+// record is called with the lock held so events are appended in the order they happened
+func (w *World) record(e Event) {
+	if w.events == nil {
+		return
+	}
+	err := w.events.Append(e)
+	if err != nil {
+		log.Println("Failed to record event: ", err)
+	}
+}
+
+func (w *World) Paused() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.paused
+}
+
+// Usernames of every player the world knows, sorted
+func (w *World) Usernames() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.usernames()
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+	if paused {
+		w.record(Event{Kind: EventGamePaused})
+	} else {
+		w.record(Event{Kind: EventGameResumed})
+	}
+}
+
+// player creates the player's record, only once they spawned a unit so a command the
+// world refuses does not make them part of the match
+func (w *World) player(username string) *Player {
+	p, ok := w.players[username]
+	if !ok {
+		p = &Player{Username: username, Units: map[int]Unit{}}
+		w.players[username] = p
+		if _, ok := w.resources[username]; !ok {
+			w.resources[username] = StartingResources
+		}
+	}
+	return p
+}
+
+func (w *World) Spawn(username string, location Location, rank UnitRank) (Unit, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn != 0 {
+		return Unit{}, errors.New("the game is played in turns, spawns are orders for the turn")
+	}
+	err := w.playing()
+	if err != nil {
+		return Unit{}, err
+	}
+	return w.spawn(username, location, rank)
+}
+
+// spawn is called with the lock held
+func (w *World) spawn(username string, location Location, rank UnitRank) (Unit, error) {
+	err := validateSpawn(w.gameMap, w.catalog, location, rank)
+	if err != nil {
+		return Unit{}, err
+	}
+
+	stats, _ := w.catalog.Stats(rank)
+	err = w.pay(username, stats.Cost)
+	if err != nil {
+		return Unit{}, err
+	}
+
+	p := w.player(username)
+	unit := Unit{
+		ID:       w.nextUnitID(username),
+		Owner:    username,
+		Rank:     rank,
+		Location: location,
+	}
+	if _, ok := p.Units[unit.ID]; ok {
+		w.resources[username] += stats.Cost
+		return Unit{}, fmt.Errorf("error: unit %v already exists", unit.Key())
+	}
+	p.Units[unit.ID] = unit
+	w.nextUnitIDs[username] = unit.ID + 1
+	total := w.resources[username]
+	w.record(Event{Kind: EventUnitSpawned, Username: username, Location: location, Units: []Unit{unit}, Resources: &total})
+	return unit, nil
+}
+
+func (w *World) nextUnitID(username string) int {
+	id, ok := w.nextUnitIDs[username]
+	if !ok {
+		id = 1
+	}
+	return id
+}
+
+// AcceptedMove is a move the world made with the players who could see where the units
+// arrived. Like the move's units they are taken before the wars the move starts, a war
+// can kill the units an observer saw the move with.
+type AcceptedMove struct {
+	ArmyMove
+	Observers []string
+}
+
+// Move moves the player's units and fights every war the move starts. The wars are
+// already resolved in the world when Move returns.
+func (w *World) Move(username string, location Location, unitIDs []int) (AcceptedMove, []RecognitionOfWar, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return AcceptedMove{}, nil, errors.New("the game is paused, you can not move units")
+	}
+	if w.turn != 0 {
+		return AcceptedMove{}, nil, errors.New("the game is played in turns, moves are orders for the turn")
+	}
+	err := w.playing()
+	if err != nil {
+		return AcceptedMove{}, nil, err
+	}
+
+	move, err := w.move(username, location, unitIDs)
+	if err != nil {
+		return AcceptedMove{}, nil, err
+	}
+	return move, w.fight(username, location), nil
+}
+
+// move only changes where the units are, it is called with the lock held
+func (w *World) move(username string, location Location, unitIDs []int) (AcceptedMove, error) {
+	err := w.validateMove(username, location, unitIDs)
+	if err != nil {
+		return AcceptedMove{}, err
+	}
+
+	p := w.player(username)
+	moved := []Unit{}
+	for _, id := range unitIDs {
+		unit := p.Units[id]
+		unit.Location = location
+		p.Units[id] = unit
+		moved = append(moved, unit)
+	}
+	w.record(Event{Kind: EventUnitsMoved, Username: username, Location: location, Units: moved})
+	return AcceptedMove{
+		ArmyMove: ArmyMove{
+			Player:     snapshot(p),
+			Units:      moved,
+			ToLocation: location,
+		},
+		Observers: w.observers(username, location),
+	}, nil
+}
+
+// validateMove is called with the lock held
+func (w *World) validateMove(username string, location Location, unitIDs []int) error {
+	if len(unitIDs) == 0 {
+		return errors.New("error: no units to move")
+	}
+	p, ok := w.players[username]
+	if !ok {
+		return errors.New("error: you have no units, spawn some first")
+	}
+	for _, id := range unitIDs {
+		unit, ok := p.Units[id]
+		if !ok {
+			return fmt.Errorf("error: unit with ID %v not found", id)
+		}
+		// units only travel along one edge per move
+		err := validateMove(w.gameMap, w.catalog, unit, location)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fight makes the player attack everyone else with units in the location, it is called with the lock held
+func (w *World) fight(username string, location Location) []RecognitionOfWar {
+	return w.battle(location, []string{username})
+}
+
+// battle makes every attacker in turn fight everyone else with units in the location.
+// Two players only fight once, however many of them attacked. It is called with the lock held.
+func (w *World) battle(location Location, attackers []string) []RecognitionOfWar {
+	wars := []RecognitionOfWar{}
+	fought := map[[2]string]bool{}
+	for _, username := range attackers {
+		p := w.player(username)
+		for _, name := range w.usernames() {
+			if name == username || w.diplomacy.AtPeace(username, name, time.Now()) {
+				continue
+			}
+			pair := [2]string{username, name}
+			if name < username {
+				pair = [2]string{name, username}
+			}
+			if fought[pair] {
+				continue
+			}
+			fought[pair] = true
+
+			other := w.players[name]
+			rw := RecognitionOfWar{
+				Attacker: unitsIn(p, location),
+				Defender: unitsIn(other, location),
+				Mode:     w.combat,
+			}
+			if w.combat == CombatDice {
+				rw.Seed = rand.Int63()
+			}
+			result, ok := ResolveWar(rw, w.catalog)
+			if !ok {
+				continue
+			}
+			wars = append(wars, rw)
+			w.record(Event{Kind: EventWarFought, Username: username, Location: location, War: &rw})
+			for _, unit := range result.CasualtiesOf(username) {
+				delete(p.Units, unit.ID)
+			}
+			for _, unit := range result.CasualtiesOf(name) {
+				delete(other.Units, unit.ID)
+			}
+		}
+	}
+	return wars
+}
+
+// usernames are sorted so wars are fought in the same order every time
+func (w *World) usernames() []string {
+	names := []string{}
+	for name := range w.players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Player returns a copy of the player's units
+func (w *World) Player(username string) (Player, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	p, ok := w.players[username]
+	if !ok {
+		return Player{}, false
+	}
+	return snapshot(p), true
+}
+
+func snapshot(p *Player) Player {
+	units := map[int]Unit{}
+	for k, v := range p.Units {
+		units[k] = v
+	}
+	return Player{Username: p.Username, Units: units}
+}
+
+// unitsIn only shows the units that take part in a war at the location
+func unitsIn(p *Player, location Location) Player {
+	units := map[int]Unit{}
+	for k, v := range p.Units {
+		if v.Location == location {
+			units[k] = v
+		}
+	}
+	return Player{Username: p.Username, Units: units}
+}
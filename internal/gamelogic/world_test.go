@@ -0,0 +1,144 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestWorld(t *testing.T) *World {
+	t.Helper()
+	w := NewWorld()
+	_, err := w.StartMatch(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func mustSpawn(t *testing.T, w *World, username string, location Location, rank UnitRank) Unit {
+	t.Helper()
+	unit, err := w.Spawn(username, location, rank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unit
+}
+
+func TestWorldRejectsCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		command func(w *World) error
+		// err is part of the error the player is sent
+		err string
+	}{
+		{
+			name: "spawn in an unknown location",
+			command: func(w *World) error {
+				_, err := w.Spawn("alice", "atlantis", RankInfantry)
+				return err
+			},
+			err: "atlantis is not a valid location",
+		},
+		{
+			name: "spawn an unknown rank",
+			command: func(w *World) error {
+				_, err := w.Spawn("alice", "europe", "dragon")
+				return err
+			},
+			err: "dragon is not a valid unit",
+		},
+		{
+			name: "spawn without the resources",
+			command: func(w *World) error {
+				w.mu.Lock()
+				w.resources["alice"] = 0
+				w.mu.Unlock()
+				_, err := w.Spawn("alice", "europe", RankInfantry)
+				return err
+			},
+			err: "not enough resources",
+		},
+		{
+			name: "move to an unknown location",
+			command: func(w *World) error {
+				_, _, err := w.Move("alice", "atlantis", []int{1})
+				return err
+			},
+			err: "atlantis is not a valid location",
+		},
+		{
+			name: "move another player's unit",
+			command: func(w *World) error {
+				// bob's unit 2 is the artillery in asia, alice only has unit 1
+				_, _, err := w.Move("alice", "asia", []int{2})
+				return err
+			},
+			err: "unit with ID 2 not found",
+		},
+		{
+			name: "move without units",
+			command: func(w *World) error {
+				_, _, err := w.Move("alice", "asia", nil)
+				return err
+			},
+			err: "no units to move",
+		},
+		{
+			name: "move while paused",
+			command: func(w *World) error {
+				w.SetPaused(true)
+				_, _, err := w.Move("alice", "asia", []int{1})
+				return err
+			},
+			err: "the game is paused",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			mustSpawn(t, w, "alice", "europe", RankInfantry)
+			mustSpawn(t, w, "bob", "asia", RankInfantry)
+			mustSpawn(t, w, "bob", "asia", RankArtillery)
+
+			err := tt.command(w)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+			}
+
+			// a refused command leaves every unit where it was
+			alice, _ := w.Player("alice")
+			if len(alice.Units) != 1 || alice.Units[1].Location != "europe" {
+				t.Errorf("alice has %v, want the infantry in europe", alice.Units)
+			}
+			bob, _ := w.Player("bob")
+			if len(bob.Units) != 2 || bob.Units[2].Location != "asia" {
+				t.Errorf("bob has %v, want both units in asia", bob.Units)
+			}
+		})
+	}
+}
+
+func TestWorldMoveFightsWars(t *testing.T) {
+	w := newTestWorld(t)
+	mustSpawn(t, w, "alice", "europe", RankInfantry)
+	mustSpawn(t, w, "bob", "asia", RankArtillery)
+
+	move, wars, err := w.Move("alice", "asia", []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(move.Units) != 1 || move.ToLocation != "asia" {
+		t.Errorf("move = %+v, want unit 1 to asia", move)
+	}
+	if len(wars) != 1 || wars[0].Attacker.Username != "alice" || wars[0].Defender.Username != "bob" {
+		t.Fatalf("wars = %+v, want alice attacking bob", wars)
+	}
+	if alice, _ := w.Player("alice"); len(alice.Units) != 0 {
+		t.Errorf("alice still has %v units after losing", len(alice.Units))
+	}
+	if bob, _ := w.Player("bob"); len(bob.Units) != 1 {
+		t.Errorf("bob has %v units after winning, want 1", len(bob.Units))
+	}
+}
@@ -0,0 +1,27 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Broker is everything the rest of pubsub needs from a message broker.
+// Connection talks to RabbitMQ, MemoryBroker runs in-process for tests.
+type Broker interface {
+	DeclareExchange(name, kind string) error
+	DeclareQueue(name string, simpleQueueType QueueType, args amqp.Table) (amqp.Queue, error)
+	BindQueue(queueName, key, exchange string) error
+
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+	// PublishConfirm publishes with mandatory=true and waits for the broker to accept the message.
+	// An unroutable message is reported as a *ReturnedError.
+	PublishConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+
+	// Consume starts a consumer with at most prefetch unacked deliveries. The delivery
+	// channel is closed once the consumer is cancelled and its buffered deliveries are read.
+	Consume(queueName, consumerTag string, prefetch int) (<-chan amqp.Delivery, error)
+	Cancel(consumerTag string) error
+
+	Close() error
+}
@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeGob      = "application/gob"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// Codec turns values into message bodies and back for one AMQP content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into v, which is always a pointer
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(GobCodec{})
+	RegisterCodec(MsgPackCodec{})
+	RegisterCodec(ProtobufCodec{})
+}
+
+// RegisterCodec adds c to the registry, replacing any codec with the same content type.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+func CodecFor(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("pubsub: no codec registered for content type %q", contentType)
+	}
+	return c, nil
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) ContentType() string { return ContentTypeGob }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	encoder := gob.NewEncoder(&buf)
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	decoder := gob.NewDecoder(bytes.NewBuffer(data))
+	return decoder.Decode(v)
+}
+
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) ContentType() string { return ContentTypeMsgPack }
+
+func (MsgPackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgPackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// ProtobufCodec only works with generated message types, so T has to be
+// the pointer type, e.g. Publish[*pb.ArmyMove].
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("pubsub: %T is not a protobuf message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+
+	// v is a **Message, the message itself has to be allocated first
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("pubsub: %T is not a pointer to a protobuf message", v)
+	}
+	if rv.Elem().IsNil() {
+		rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+	}
+	msg, ok := rv.Elem().Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("pubsub: %T is not a pointer to a protobuf message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecMessage struct {
+	Username string
+	Units    []int
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+	}{
+		{ContentTypeJSON, JSONCodec{}},
+		{ContentTypeGob, GobCodec{}},
+		{ContentTypeMsgPack, MsgPackCodec{}},
+		{ContentTypeProtobuf, ProtobufCodec{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			c, err := CodecFor(tt.contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.want || c.ContentType() != tt.contentType {
+				t.Errorf("CodecFor(%q) = %#v", tt.contentType, c)
+			}
+		})
+	}
+}
+
+func TestCodecForUnknownContentType(t *testing.T) {
+	_, err := CodecFor("text/plain")
+	if err == nil || !strings.Contains(err.Error(), `no codec registered for content type "text/plain"`) {
+		t.Errorf("err = %v, want no codec registered", err)
+	}
+
+	_, err = encode("text/plain", codecMessage{})
+	if err == nil {
+		t.Error("encoded a message with an unknown content type")
+	}
+	_, err = decode[codecMessage](amqp.Delivery{ContentType: "text/plain", Body: []byte("hi")})
+	if err == nil {
+		t.Error("decoded a message with an unknown content type")
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	msg := codecMessage{Username: "alice", Units: []int{1, 2, 3}}
+
+	tests := []struct {
+		contentType string
+	}{
+		{ContentTypeJSON},
+		{ContentTypeGob},
+		{ContentTypeMsgPack},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			publishing, err := encode(tt.contentType, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if publishing.ContentType != tt.contentType {
+				t.Errorf("content type = %q, want %q", publishing.ContentType, tt.contentType)
+			}
+
+			got, err := decode[codecMessage](amqp.Delivery{ContentType: publishing.ContentType, Body: publishing.Body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("decoded %+v, want %+v", got, msg)
+			}
+		})
+	}
+}
+
+func TestDecodeWithoutContentTypeIsJSON(t *testing.T) {
+	got, err := decode[codecMessage](amqp.Delivery{Body: []byte(`{"Username":"bob"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "bob" {
+		t.Errorf("decoded %+v, want bob", got)
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	msg := wrapperspb.String("alice")
+
+	publishing, err := encode(ContentTypeProtobuf, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// T is the pointer type, decode allocates the message it unmarshals into
+	got, err := decode[*wrapperspb.StringValue](amqp.Delivery{ContentType: ContentTypeProtobuf, Body: publishing.Body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !proto.Equal(got, msg) {
+		t.Errorf("decoded %v, want %v", got, msg)
+	}
+}
+
+func TestProtobufRefusesOtherTypes(t *testing.T) {
+	_, err := encode(ContentTypeProtobuf, codecMessage{Username: "alice"})
+	if err == nil {
+		t.Error("encoded a struct that is not a protobuf message")
+	}
+
+	_, err = decode[codecMessage](amqp.Delivery{ContentType: ContentTypeProtobuf, Body: []byte{}})
+	if err == nil {
+		t.Error("decoded into a struct that is not a protobuf message")
+	}
+}
@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrPublishNacked = errors.New("pubsub: broker nacked the message")
+
+// ReturnedError is returned by PublishConfirm when the broker could not route
+// the message to any queue and sent it back.
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("pubsub: message to %v with key %v was returned: %v %v", e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+// confirmPublisher publishes with mandatory=true on a channel in confirm mode and
+// waits for the broker to ack each message, so the caller knows it was actually routed.
+// Publishes are serialized so a returned message can be matched to its publish.
+type confirmPublisher struct {
+	conn *Connection
+
+	mu      sync.Mutex
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+// PublishConfirm is Publish, but only returns nil once the broker accepted and routed the message.
+func PublishConfirm[T any](ctx context.Context, b Broker, exchange, key, contentType string, val T) error {
+	msg, err := encode(contentType, val)
+	if err != nil {
+		return err
+	}
+	return b.PublishConfirm(ctx, exchange, key, msg)
+}
+
+func (p *confirmPublisher) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch == nil {
+		return nil
+	}
+	err := p.ch.Close()
+	p.ch = nil
+	return err
+}
+
+func (p *confirmPublisher) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// a late return or confirm would be matched with the next publish, so start over on a new channel
+		p.ch.Close()
+		p.ch = nil
+		return err
+	}
+
+	// the broker sends basic.return before the ack, so it is already here if the message was unroutable
+	select {
+	case ret := <-p.returns:
+		return &ReturnedError{
+			Exchange:   ret.Exchange,
+			RoutingKey: ret.RoutingKey,
+			ReplyCode:  ret.ReplyCode,
+			ReplyText:  ret.ReplyText,
+		}
+	default:
+	}
+
+	if !acked {
+		return ErrPublishNacked
+	}
+	return nil
+}
+
+func (p *confirmPublisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	p.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	p.ch = ch
+	return ch, nil
+}
@@ -0,0 +1,553 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+var ErrConnectionClosed = errors.New("pubsub: connection is closed")
+
+// Connection is the RabbitMQ Broker. It wraps amqp.Connection and keeps it alive:
+// when the broker goes away it redials with backoff, re-declares every exchange,
+// queue and binding made through it and restarts every consumer, so the delivery
+// channels returned by Consume keep delivering after a reconnect.
+type Connection struct {
+	url string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	pubChan   *amqp.Channel
+	confirmer *confirmPublisher
+	closed    bool
+
+	declarations []declaration
+	consumers    map[string]*consumer
+}
+
+type declarationKind int
+
+const (
+	declareExchange declarationKind = iota
+	declareQueue
+	bindQueue
+)
+
+type declaration struct {
+	kind            declarationKind
+	name            string // exchange or queue name
+	exchangeKind    string
+	simpleQueueType QueueType
+	args            amqp.Table
+	key             string
+	exchange        string
+}
+
+// consumer forwards deliveries from whatever amqp channel is current into out,
+// which stays open across reconnects until the consumer is cancelled.
+type consumer struct {
+	queueName string
+	tag       string
+	prefetch  int
+	out       chan amqp.Delivery
+	// stopped is closed when the consumer is aborted, the forwarder stops without
+	// waiting for the channel to close its deliveries
+	stopped chan struct{}
+
+	mu         sync.Mutex
+	ch         *amqp.Channel
+	generation int
+	running    bool
+	cancelled  bool
+	aborted    bool
+}
+
+var _ Broker = (*Connection)(nil)
+
+func Dial(url string) (*Connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{
+		url:       url,
+		conn:      conn,
+		consumers: map[string]*consumer{},
+	}
+	c.confirmer = &confirmPublisher{conn: c}
+
+	err = c.restoreTopology(conn, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.watch(conn)
+	return c, nil
+}
+
+// Channel opens a new channel on the current underlying connection.
+// Channels returned here are not recovered after a reconnect.
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return nil, ErrConnectionClosed
+	}
+	return c.conn.Channel()
+}
+
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	consumers := c.consumers
+	c.consumers = map[string]*consumer{}
+	c.mu.Unlock()
+
+	for _, cons := range consumers {
+		cons.cancel()
+	}
+	c.confirmer.close()
+	return c.conn.Close()
+}
+
+func (c *Connection) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed
+}
+
+func (c *Connection) DeclareExchange(name, kind string) error {
+	d := declaration{kind: declareExchange, name: name, exchangeKind: kind}
+	return c.declare(d)
+}
+
+func (c *Connection) DeclareQueue(name string, simpleQueueType QueueType, args amqp.Table) (amqp.Queue, error) {
+	ch, err := c.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+
+	d := declaration{kind: declareQueue, name: name, simpleQueueType: simpleQueueType, args: args}
+	queue, err := d.queueDeclare(ch)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	c.addDeclaration(d)
+	return queue, nil
+}
+
+func (c *Connection) BindQueue(queueName, key, exchange string) error {
+	d := declaration{kind: bindQueue, name: queueName, key: key, exchange: exchange}
+	return c.declare(d)
+}
+
+func (c *Connection) declare(d declaration) error {
+	// a failed declaration closes the channel, so every declaration gets its own
+	ch, err := c.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = d.apply(ch)
+	if err != nil {
+		return err
+	}
+	c.addDeclaration(d)
+	return nil
+}
+
+func (d declaration) apply(ch *amqp.Channel) error {
+	switch d.kind {
+	case declareExchange:
+		return ch.ExchangeDeclare(d.name, d.exchangeKind, true, false, false, false, nil)
+	case declareQueue:
+		_, err := d.queueDeclare(ch)
+		return err
+	case bindQueue:
+		return ch.QueueBind(d.name, d.key, d.exchange, false, nil)
+	}
+	return nil
+}
+
+func (d declaration) queueDeclare(ch *amqp.Channel) (amqp.Queue, error) {
+	isDurable := true
+	autoDelete := false
+	exclusive := false
+	if d.simpleQueueType == TransientQueue {
+		isDurable = false
+		autoDelete = true
+		exclusive = true
+	}
+	return ch.QueueDeclare(d.name, isDurable, autoDelete, exclusive, false, d.args)
+}
+
+func (c *Connection) addDeclaration(d declaration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.declarations {
+		if reflect.DeepEqual(existing, d) {
+			return
+		}
+	}
+	c.declarations = append(c.declarations, d)
+}
+
+func (c *Connection) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	ch, err := c.publishChannel()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+func (c *Connection) PublishConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	return c.confirmer.publish(ctx, exchange, key, msg)
+}
+
+// publishChannel returns the shared channel used by Publish,
+// reopening it if it was closed by the broker or by a reconnect.
+func (c *Connection) publishChannel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrConnectionClosed
+	}
+	if c.pubChan != nil && !c.pubChan.IsClosed() {
+		return c.pubChan, nil
+	}
+
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	c.pubChan = ch
+	return ch, nil
+}
+
+func (c *Connection) Consume(queueName, consumerTag string, prefetch int) (<-chan amqp.Delivery, error) {
+	cons := &consumer{
+		queueName: queueName,
+		tag:       consumerTag,
+		prefetch:  prefetch,
+		out:       make(chan amqp.Delivery),
+		stopped:   make(chan struct{}),
+	}
+
+	err := cons.start(c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cons.cancel()
+		return nil, ErrConnectionClosed
+	}
+	c.consumers[consumerTag] = cons
+	return cons.out, nil
+}
+
+func (c *Connection) Cancel(consumerTag string) error {
+	c.mu.Lock()
+	cons, ok := c.consumers[consumerTag]
+	delete(c.consumers, consumerTag)
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return cons.cancel()
+}
+
+func (cons *consumer) start(c *Connection) error {
+	ch, err := c.Channel()
+	if err != nil {
+		return err
+	}
+	closes := ch.NotifyClose(make(chan *amqp.Error, 1))
+	cancels := ch.NotifyCancel(make(chan string, 1))
+
+	err = ch.Qos(cons.prefetch, 0, false)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	in, err := ch.Consume(cons.queueName, cons.tag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	cons.mu.Lock()
+	if cons.cancelled {
+		cons.mu.Unlock()
+		ch.Close()
+		return nil
+	}
+	// the connection watch and the channel watch can both restart a consumer,
+	// the last start wins and the channel it replaces is closed
+	previous := cons.ch
+	cons.generation++
+	cons.ch = ch
+	cons.running = true
+	generation := cons.generation
+	cons.mu.Unlock()
+
+	if previous != nil && !previous.IsClosed() {
+		previous.Close()
+	}
+
+	go cons.forward(in, generation)
+	go cons.watch(c, ch, generation, closes, cancels)
+	return nil
+}
+
+// watch restarts the consumer when the broker closes its channel or cancels it,
+// for example after a channel error or once its queue was deleted
+func (cons *consumer) watch(c *Connection, ch *amqp.Channel, generation int, closes chan *amqp.Error, cancels chan string) {
+	select {
+	case amqpErr, ok := <-closes:
+		if !ok || amqpErr == nil {
+			// closed on purpose
+			return
+		}
+		log.Println("AMQP channel of consumer", cons.tag, "closed: ", amqpErr)
+	case _, ok := <-cancels:
+		if !ok {
+			return
+		}
+		log.Println("AMQP consumer", cons.tag, "cancelled by the broker")
+		ch.Close()
+	}
+	c.recoverConsumer(cons, generation)
+}
+
+func (cons *consumer) current(generation int) bool {
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	return !cons.cancelled && cons.generation == generation
+}
+
+func (cons *consumer) forward(in <-chan amqp.Delivery, generation int) {
+	cons.pipe(in)
+
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	if cons.generation != generation {
+		return
+	}
+	cons.running = false
+	// if the channel just died the reconnect starts a new forwarder instead
+	if cons.cancelled {
+		close(cons.out)
+		// an aborted consumer's channel was already closed by cancel
+		if !cons.aborted {
+			cons.ch.Close()
+		}
+	}
+}
+
+// pipe hands deliveries to out until in is closed or the consumer is aborted
+func (cons *consumer) pipe(in <-chan amqp.Delivery) {
+	for {
+		select {
+		case delivery, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case cons.out <- delivery:
+			case <-cons.stopped:
+				return
+			}
+		case <-cons.stopped:
+			return
+		}
+	}
+}
+
+func (cons *consumer) cancel() error {
+	cons.mu.Lock()
+	if cons.cancelled {
+		cons.mu.Unlock()
+		return nil
+	}
+	cons.cancelled = true
+	if !cons.running {
+		close(cons.out)
+		cons.mu.Unlock()
+		return nil
+	}
+	ch := cons.ch
+	cons.mu.Unlock()
+
+	// cancelling closes the amqp delivery channel once the already received deliveries
+	// are handed out, the forwarder then closes out
+	err := ch.Cancel(cons.tag, false)
+	if err != nil {
+		cons.abort()
+		ch.Close()
+		return err
+	}
+	return nil
+}
+
+// abort stops the forwarder when the channel could not cancel the consumer. The channel
+// might never close its deliveries, out would stay open and Subscription.Close would wait
+// for it forever. Deliveries that were not forwarded are redelivered by the broker.
+func (cons *consumer) abort() {
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	cons.aborted = true
+	close(cons.stopped)
+}
+
+func (c *Connection) watch(conn *amqp.Connection) {
+	amqpErr, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+	if !ok || amqpErr == nil || c.isClosed() {
+		// closed on purpose
+		return
+	}
+	log.Println("AMQP connection lost: ", amqpErr)
+	c.reconnect()
+}
+
+func (c *Connection) reconnect() {
+	delay := minReconnectDelay
+	for {
+		time.Sleep(delay)
+		if c.isClosed() {
+			return
+		}
+
+		err := c.redial()
+		if err == nil {
+			log.Println("AMQP connection recovered")
+			return
+		}
+		log.Println("Failed to reconnect, retrying in", delay, ": ", err)
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (c *Connection) redial() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrConnectionClosed
+	}
+	c.conn = conn
+	c.pubChan = nil
+	declarations := append([]declaration(nil), c.declarations...)
+	consumers := []*consumer{}
+	for _, cons := range c.consumers {
+		consumers = append(consumers, cons)
+	}
+	c.mu.Unlock()
+
+	err = c.restoreTopology(conn, declarations)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	for _, cons := range consumers {
+		err = cons.start(c)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	go c.watch(conn)
+	return nil
+}
+
+// recoverConsumer re-declares the consumer's queue and its bindings and consumes it on a new
+// channel. When the whole connection is lost the connection watch restarts it instead.
+func (c *Connection) recoverConsumer(cons *consumer, generation int) {
+	delay := minReconnectDelay
+	for {
+		if c.isClosed() || !cons.current(generation) {
+			return
+		}
+		c.mu.RLock()
+		conn := c.conn
+		declarations := []declaration{}
+		for _, d := range c.declarations {
+			if d.kind == declareExchange || d.name == cons.queueName {
+				declarations = append(declarations, d)
+			}
+		}
+		c.mu.RUnlock()
+		if conn.IsClosed() {
+			return
+		}
+
+		err := c.restoreTopology(conn, declarations)
+		if err == nil {
+			err = cons.start(c)
+		}
+		if err == nil {
+			log.Println("AMQP consumer", cons.tag, "recovered")
+			return
+		}
+		log.Println("Failed to recover consumer", cons.tag, ", retrying in", delay, ": ", err)
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (c *Connection) restoreTopology(conn *amqp.Connection, declarations []declaration) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = declareDeadLetter(ch)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range declarations {
+		err = d.apply(ch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
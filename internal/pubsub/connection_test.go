@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestConsumerAbortClosesOut(t *testing.T) {
+	tests := []struct {
+		name string
+		// pending is how many deliveries the channel has that nobody reads from out
+		pending int
+	}{
+		{"waiting for deliveries", 0},
+		{"waiting for a reader", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the channel never closes its deliveries, like one whose cancel failed
+			in := make(chan amqp.Delivery, tt.pending)
+			for i := 0; i < tt.pending; i++ {
+				in <- amqp.Delivery{DeliveryTag: uint64(i + 1)}
+			}
+			cons := &consumer{
+				tag:        "test",
+				out:        make(chan amqp.Delivery),
+				stopped:    make(chan struct{}),
+				generation: 1,
+				running:    true,
+				cancelled:  true,
+			}
+			done := make(chan struct{})
+			go func() {
+				cons.forward(in, 1)
+				close(done)
+			}()
+
+			cons.abort()
+			select {
+			case <-done:
+			case <-time.After(testTimeout):
+				t.Fatal("the forwarder still waits after the consumer was aborted")
+			}
+			if _, ok := <-cons.out; ok {
+				t.Error("out is still open")
+			}
+			cons.mu.Lock()
+			defer cons.mu.Unlock()
+			if cons.running {
+				t.Error("the consumer is still running")
+			}
+		})
+	}
+}
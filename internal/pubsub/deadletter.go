@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a message taken from peril_dlq together with its oldest x-death entry,
+// i.e. where the message originally came from and why it was first dead-lettered.
+// RabbitMQ puts the most recent death first, so after a hop through a retry or
+// quarantine queue the first entry is that queue and not the original one.
+type DeadLetter struct {
+	Delivery amqp.Delivery
+
+	Reason      string
+	Queue       string
+	Exchange    string
+	RoutingKeys []string
+	Count       int64
+	Time        time.Time
+}
+
+// declareDeadLetter declares the fanout exchange every queue dead-letters to
+// and the peril_dlq queue that collects those messages.
+func declareDeadLetter(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(routing.ExchangePerilDLX, amqp.ExchangeFanout, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(routing.QueuePerilDLQ, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(routing.QueuePerilDLQ, "", routing.ExchangePerilDLX, false, nil)
+}
+
+// FetchDeadLetters gets every message currently in peril_dlq without acking them.
+// The messages go back to the queue when they are nacked or when ch is closed.
+func FetchDeadLetters(ch *amqp.Channel) ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	for {
+		delivery, ok, err := ch.Get(routing.QueuePerilDLQ, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return deadLetters, nil
+		}
+		deadLetters = append(deadLetters, parseDeadLetter(delivery))
+	}
+}
+
+func parseDeadLetter(delivery amqp.Delivery) DeadLetter {
+	dl := DeadLetter{Delivery: delivery}
+
+	deaths, ok := delivery.Headers["x-death"].([]interface{})
+	if !ok || len(deaths) == 0 {
+		return dl
+	}
+	death, ok := deaths[len(deaths)-1].(amqp.Table)
+	if !ok {
+		return dl
+	}
+
+	dl.Reason, _ = death["reason"].(string)
+	dl.Queue, _ = death["queue"].(string)
+	dl.Exchange, _ = death["exchange"].(string)
+	dl.Count, _ = death["count"].(int64)
+	dl.Time, _ = death["time"].(time.Time)
+	keys, _ := death["routing-keys"].([]interface{})
+	for _, key := range keys {
+		if k, ok := key.(string); ok {
+			dl.RoutingKeys = append(dl.RoutingKeys, k)
+		}
+	}
+
+	// a message republished by a subscription was dead-lettered from the retry or
+	// quarantine queue, the headers still know where it was published first
+	if exchange, ok := delivery.Headers[originalExchange].(string); ok {
+		dl.Exchange = exchange
+		if key, ok := delivery.Headers[originalRoutingKey].(string); ok {
+			dl.RoutingKeys = []string{key}
+		}
+	}
+	return dl
+}
+
+// Replay publishes the message back to the exchange and routing key it was
+// originally published with and acks it in peril_dlq.
+func (dl DeadLetter) Replay(ctx context.Context, ch *amqp.Channel) error {
+	if len(dl.RoutingKeys) == 0 {
+		return errors.New("dead letter has no x-death routing key, can not replay")
+	}
+
+	err := ch.PublishWithContext(ctx, dl.Exchange, dl.RoutingKeys[0], false, false, amqp.Publishing{
+		Headers:      replayHeaders(dl.Delivery.Headers),
+		ContentType:  dl.Delivery.ContentType,
+		DeliveryMode: dl.Delivery.DeliveryMode,
+		MessageId:    dl.Delivery.MessageId,
+		Timestamp:    dl.Delivery.Timestamp,
+		Body:         dl.Delivery.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return dl.Delivery.Ack(false)
+}
+
+// replayHeaders drops the headers the broker added when it dead-lettered the message
+func replayHeaders(headers amqp.Table) amqp.Table {
+	replayed := amqp.Table{}
+	for k, v := range headers {
+		if k == "x-death" || k == "x-first-death-exchange" || k == "x-first-death-queue" || k == "x-first-death-reason" || k == "x-last-death-exchange" || k == "x-last-death-queue" || k == "x-last-death-reason" || k == originalExchange || k == originalRoutingKey {
+			continue
+		}
+		replayed[k] = v
+	}
+	return replayed
+}
@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestParseDeadLetter(t *testing.T) {
+	died := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		reason   string
+		queue    string
+		exchange string
+		keys     []string
+		count    int64
+	}{
+		{
+			// the broker puts the most recent death first
+			name: "oldest x-death entry",
+			headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{"reason": "expired", "queue": "army_moves.alice.retry", "exchange": "", "routing-keys": []interface{}{"army_moves.alice"}},
+				amqp.Table{
+					"reason":       "rejected",
+					"queue":        "army_moves.alice",
+					"exchange":     "peril_topic",
+					"routing-keys": []interface{}{"army_moves.bob"},
+					"count":        int64(2),
+					"time":         died,
+				},
+			}},
+			reason:   "rejected",
+			queue:    "army_moves.alice",
+			exchange: "peril_topic",
+			keys:     []string{"army_moves.bob"},
+			count:    2,
+		},
+		{
+			name: "republished by a subscription",
+			headers: amqp.Table{
+				"x-death": []interface{}{
+					amqp.Table{"reason": "rejected", "queue": "army_moves.alice.quarantine", "exchange": "", "routing-keys": []interface{}{"army_moves.alice.quarantine"}},
+				},
+				originalExchange:   "peril_topic",
+				originalRoutingKey: "army_moves.bob",
+			},
+			reason:   "rejected",
+			queue:    "army_moves.alice.quarantine",
+			exchange: "peril_topic",
+			keys:     []string{"army_moves.bob"},
+		},
+		{
+			name: "original exchange without a routing key",
+			headers: amqp.Table{
+				"x-death": []interface{}{
+					amqp.Table{"reason": "rejected", "queue": "army_moves.alice.quarantine", "exchange": "", "routing-keys": []interface{}{"army_moves.alice.quarantine"}},
+				},
+				originalExchange: "peril_topic",
+			},
+			reason:   "rejected",
+			queue:    "army_moves.alice.quarantine",
+			exchange: "peril_topic",
+			keys:     []string{"army_moves.alice.quarantine"},
+		},
+		{
+			name: "original headers of the wrong type",
+			headers: amqp.Table{
+				"x-death": []interface{}{
+					amqp.Table{"reason": "rejected", "queue": "army_moves.alice", "exchange": "peril_topic", "routing-keys": []interface{}{"army_moves.bob"}},
+				},
+				originalExchange:   42,
+				originalRoutingKey: "army_moves.carol",
+			},
+			reason:   "rejected",
+			queue:    "army_moves.alice",
+			exchange: "peril_topic",
+			keys:     []string{"army_moves.bob"},
+		},
+		{
+			name:    "no headers",
+			headers: nil,
+		},
+		{
+			name:    "no x-death entries",
+			headers: amqp.Table{"x-death": []interface{}{}},
+		},
+		{
+			name:    "x-death is not a list",
+			headers: amqp.Table{"x-death": "rejected"},
+		},
+		{
+			name:    "x-death entry is not a table",
+			headers: amqp.Table{"x-death": []interface{}{"rejected"}},
+		},
+		{
+			name: "fields of the wrong type",
+			headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{
+					"reason":       "rejected",
+					"queue":        42,
+					"routing-keys": []interface{}{7, "army_moves.bob"},
+					"count":        "2",
+				},
+			}},
+			reason: "rejected",
+			keys:   []string{"army_moves.bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := parseDeadLetter(amqp.Delivery{Headers: tt.headers})
+			if dl.Reason != tt.reason || dl.Queue != tt.queue || dl.Exchange != tt.exchange || dl.Count != tt.count {
+				t.Errorf("got %q %q %q %v, want %q %q %q %v", dl.Reason, dl.Queue, dl.Exchange, dl.Count, tt.reason, tt.queue, tt.exchange, tt.count)
+			}
+			if !slices.Equal(dl.RoutingKeys, tt.keys) {
+				t.Errorf("routing keys = %v, want %v", dl.RoutingKeys, tt.keys)
+			}
+			if tt.count != 0 && !dl.Time.Equal(died) {
+				t.Errorf("time = %v, want %v", dl.Time, died)
+			}
+		})
+	}
+}
+
+func TestReplayHeaders(t *testing.T) {
+	headers := amqp.Table{
+		"x-death":                []interface{}{amqp.Table{"reason": "rejected"}},
+		"x-first-death-exchange": "peril_topic",
+		"x-first-death-queue":    "army_moves.alice",
+		"x-first-death-reason":   "rejected",
+		"x-last-death-exchange":  "peril_topic",
+		"x-last-death-queue":     "army_moves.alice",
+		"x-last-death-reason":    "rejected",
+		originalExchange:         "peril_topic",
+		originalRoutingKey:       "army_moves.bob",
+		"x-custom":               "kept",
+		"trace":                  int32(7),
+	}
+
+	replayed := replayHeaders(headers)
+	if len(replayed) != 2 || replayed["x-custom"] != "kept" || replayed["trace"] != int32(7) {
+		t.Errorf("replayed headers = %v, want only x-custom and trace", replayed)
+	}
+	if len(headers) != 11 {
+		t.Error("the dead letter's own headers were changed")
+	}
+}
@@ -0,0 +1,504 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// memoryPrefetch is used for consumers that ask for an unlimited prefetch,
+// it is also the size of their delivery buffer.
+const memoryPrefetch = 100
+
+var ErrBrokerClosed = errors.New("pubsub: broker is closed")
+
+// MemoryBroker is an in-process Broker for tests. It routes like RabbitMQ's direct,
+// topic and fanout exchanges, supports ack/nack/requeue, prefetch, x-message-ttl and
+// dead-lettering through x-dead-letter-exchange, but persists nothing and ignores
+// exclusive/auto-delete/x-expires.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]string // name -> kind
+	queues    map[string]*memoryQueue
+	bindings  []memoryBinding
+	consumers map[string]*memoryConsumer
+	unacked   map[uint64]*memoryUnacked
+	nextTag   uint64
+	nextID    uint64
+	closed    bool
+}
+
+type memoryBinding struct {
+	exchange string
+	key      string
+	queue    string
+}
+
+type memoryQueue struct {
+	name      string
+	args      amqp.Table
+	messages  []memoryMessage
+	consumers []*memoryConsumer
+	next      int // round robin position in consumers
+}
+
+type memoryMessage struct {
+	id          uint64
+	exchange    string
+	key         string
+	msg         amqp.Publishing
+	redelivered bool
+}
+
+type memoryConsumer struct {
+	tag      string
+	queue    *memoryQueue
+	prefetch int
+	inFlight int
+	out      chan amqp.Delivery
+}
+
+type memoryUnacked struct {
+	consumer *memoryConsumer
+	message  memoryMessage
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// NewMemoryBroker returns an empty broker with the default exchange, peril_dlx and peril_dlq,
+// the same way Dial sets them up on RabbitMQ.
+func NewMemoryBroker() *MemoryBroker {
+	mb := &MemoryBroker{
+		exchanges: map[string]string{"": amqp.ExchangeDirect},
+		queues:    map[string]*memoryQueue{},
+		consumers: map[string]*memoryConsumer{},
+		unacked:   map[uint64]*memoryUnacked{},
+	}
+	mb.DeclareExchange(routing.ExchangePerilDLX, amqp.ExchangeFanout)
+	mb.DeclareQueue(routing.QueuePerilDLQ, DurableQueue, nil)
+	mb.BindQueue(routing.QueuePerilDLQ, "", routing.ExchangePerilDLX)
+	return mb
+}
+
+func (mb *MemoryBroker) DeclareExchange(name, kind string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	if existing, ok := mb.exchanges[name]; ok && existing != kind {
+		return fmt.Errorf("pubsub: exchange %q already declared as %v", name, existing)
+	}
+	mb.exchanges[name] = kind
+	return nil
+}
+
+func (mb *MemoryBroker) DeclareQueue(name string, simpleQueueType QueueType, args amqp.Table) (amqp.Queue, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return amqp.Queue{}, ErrBrokerClosed
+	}
+
+	q, ok := mb.queues[name]
+	if !ok {
+		q = &memoryQueue{name: name, args: args}
+		mb.queues[name] = q
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+}
+
+func (mb *MemoryBroker) BindQueue(queueName, key, exchange string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	if _, ok := mb.queues[queueName]; !ok {
+		return fmt.Errorf("pubsub: no queue %q", queueName)
+	}
+	if _, ok := mb.exchanges[exchange]; !ok {
+		return fmt.Errorf("pubsub: no exchange %q", exchange)
+	}
+
+	binding := memoryBinding{exchange: exchange, key: key, queue: queueName}
+	for _, b := range mb.bindings {
+		if b == binding {
+			return nil
+		}
+	}
+	mb.bindings = append(mb.bindings, binding)
+	return nil
+}
+
+func (mb *MemoryBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	_, err := mb.route(memoryMessage{exchange: exchange, key: key, msg: msg})
+	return err
+}
+
+func (mb *MemoryBroker) PublishConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	routed, err := mb.route(memoryMessage{exchange: exchange, key: key, msg: msg})
+	if err != nil {
+		return err
+	}
+	if routed == 0 {
+		return &ReturnedError{
+			Exchange:   exchange,
+			RoutingKey: key,
+			ReplyCode:  amqp.NoRoute,
+			ReplyText:  "NO_ROUTE",
+		}
+	}
+	return nil
+}
+
+// route copies m into every queue bound to its exchange with a matching key and
+// returns how many queues got it. mb.mu must be held.
+func (mb *MemoryBroker) route(m memoryMessage) (int, error) {
+	kind, ok := mb.exchanges[m.exchange]
+	if !ok {
+		return 0, fmt.Errorf("pubsub: no exchange %q", m.exchange)
+	}
+
+	targets := []*memoryQueue{}
+	if m.exchange == "" {
+		if q, ok := mb.queues[m.key]; ok {
+			targets = append(targets, q)
+		}
+	}
+	for _, b := range mb.bindings {
+		if b.exchange != m.exchange || !bindingMatches(kind, b.key, m.key) {
+			continue
+		}
+		q := mb.queues[b.queue]
+		duplicate := false
+		for _, t := range targets {
+			if t == q {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			targets = append(targets, q)
+		}
+	}
+
+	for _, q := range targets {
+		mb.nextID++
+		queued := m
+		queued.id = mb.nextID
+		q.messages = append(q.messages, queued)
+
+		ttl := headerInt(q.args, "x-message-ttl")
+		if ttl > 0 {
+			time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+				mb.expire(q, queued.id)
+			})
+		}
+		mb.dispatch(q)
+	}
+	return len(targets), nil
+}
+
+// expire dead-letters the message if it is still waiting in the queue,
+// like RabbitMQ messages that were already delivered do not expire.
+func (mb *MemoryBroker) expire(q *memoryQueue, id uint64) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return
+	}
+	for i, m := range q.messages {
+		if m.id == id {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			mb.deadLetter(q, m, "expired")
+			return
+		}
+	}
+}
+
+func bindingMatches(kind, bindingKey, routingKey string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatches implements topic exchange patterns, * is exactly one word and # is zero or more
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(words) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != words[0] {
+		return false
+	}
+	return topicMatches(pattern[1:], words[1:])
+}
+
+// dispatch hands queued messages to consumers that have prefetch room left. mb.mu must be held.
+// Sending never blocks because a consumer's buffer is as large as its prefetch.
+func (mb *MemoryBroker) dispatch(q *memoryQueue) {
+	for len(q.messages) > 0 {
+		cons := q.nextConsumer()
+		if cons == nil {
+			return
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+
+		mb.nextTag++
+		tag := mb.nextTag
+		mb.unacked[tag] = &memoryUnacked{consumer: cons, message: m}
+		cons.inFlight++
+
+		cons.out <- amqp.Delivery{
+			Acknowledger:  mb,
+			Headers:       m.msg.Headers,
+			ContentType:   m.msg.ContentType,
+			DeliveryMode:  m.msg.DeliveryMode,
+			MessageId:     m.msg.MessageId,
+			CorrelationId: m.msg.CorrelationId,
+			ReplyTo:       m.msg.ReplyTo,
+			Timestamp:     m.msg.Timestamp,
+			Expiration:    m.msg.Expiration,
+			ConsumerTag:   cons.tag,
+			DeliveryTag:   tag,
+			Redelivered:   m.redelivered,
+			Exchange:      m.exchange,
+			RoutingKey:    m.key,
+			Body:          m.msg.Body,
+		}
+	}
+}
+
+func (q *memoryQueue) nextConsumer() *memoryConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		cons := q.consumers[(q.next+i)%len(q.consumers)]
+		if cons.inFlight < cons.prefetch {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return cons
+		}
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Consume(queueName, consumerTag string, prefetch int) (<-chan amqp.Delivery, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return nil, ErrBrokerClosed
+	}
+
+	q, ok := mb.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("pubsub: no queue %q", queueName)
+	}
+	if consumerTag == "" {
+		consumerTag = newConsumerTag(queueName)
+	}
+	if _, ok := mb.consumers[consumerTag]; ok {
+		return nil, fmt.Errorf("pubsub: consumer tag %q already in use", consumerTag)
+	}
+	if prefetch <= 0 || prefetch > memoryPrefetch {
+		prefetch = memoryPrefetch
+	}
+
+	cons := &memoryConsumer{
+		tag:      consumerTag,
+		queue:    q,
+		prefetch: prefetch,
+		out:      make(chan amqp.Delivery, prefetch),
+	}
+	mb.consumers[consumerTag] = cons
+	q.consumers = append(q.consumers, cons)
+	mb.dispatch(q)
+	return cons.out, nil
+}
+
+func (mb *MemoryBroker) Cancel(consumerTag string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	cons, ok := mb.consumers[consumerTag]
+	if !ok {
+		return nil
+	}
+	delete(mb.consumers, consumerTag)
+
+	q := cons.queue
+	for i, c := range q.consumers {
+		if c == cons {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	q.next = 0
+	// deliveries already in the buffer can still be read and acked
+	close(cons.out)
+	return nil
+}
+
+// Close stops every consumer and requeues everything that was not acked.
+func (mb *MemoryBroker) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return nil
+	}
+	mb.closed = true
+
+	for tag, u := range mb.unacked {
+		delete(mb.unacked, tag)
+		u.message.redelivered = true
+		u.consumer.queue.messages = append(u.consumer.queue.messages, u.message)
+	}
+	for tag, cons := range mb.consumers {
+		delete(mb.consumers, tag)
+		close(cons.out)
+	}
+	return nil
+}
+
+// QueueLength is the number of messages waiting in the queue, not counting unacked ones.
+func (mb *MemoryBroker) QueueLength(queueName string) int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	q, ok := mb.queues[queueName]
+	if !ok {
+		return 0
+	}
+	return len(q.messages)
+}
+
+// Ack, Nack and Reject make MemoryBroker the amqp.Acknowledger of its deliveries.
+
+func (mb *MemoryBroker) Ack(tag uint64, multiple bool) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.settle(tag, multiple, func(u *memoryUnacked) {})
+}
+
+func (mb *MemoryBroker) Nack(tag uint64, multiple bool, requeue bool) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.settle(tag, multiple, func(u *memoryUnacked) {
+		q := u.consumer.queue
+		if requeue {
+			u.message.redelivered = true
+			q.messages = append([]memoryMessage{u.message}, q.messages...)
+			return
+		}
+		mb.deadLetter(q, u.message, "rejected")
+	})
+}
+
+func (mb *MemoryBroker) Reject(tag uint64, requeue bool) error {
+	return mb.Nack(tag, false, requeue)
+}
+
+// settle removes tag (and with multiple every older tag of the same consumer) from the
+// unacked deliveries, runs fn for each and refills the consumers. mb.mu must be held.
+func (mb *MemoryBroker) settle(tag uint64, multiple bool, fn func(u *memoryUnacked)) error {
+	u, ok := mb.unacked[tag]
+	if !ok {
+		return fmt.Errorf("pubsub: unknown delivery tag %v", tag)
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		for t, other := range mb.unacked {
+			if t < tag && other.consumer == u.consumer {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	queues := map[*memoryQueue]struct{}{}
+	for _, t := range tags {
+		settled := mb.unacked[t]
+		delete(mb.unacked, t)
+		settled.consumer.inFlight--
+		fn(settled)
+		queues[settled.consumer.queue] = struct{}{}
+	}
+	for q := range queues {
+		mb.dispatch(q)
+	}
+	return nil
+}
+
+// deadLetter publishes m to the queue's x-dead-letter-exchange with an x-death header
+// like RabbitMQ adds, or drops it if the queue has none. mb.mu must be held.
+func (mb *MemoryBroker) deadLetter(q *memoryQueue, m memoryMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := m.key
+	if dlKey, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlKey
+	}
+
+	headers := copyHeaders(m.msg.Headers)
+	deaths, _ := headers["x-death"].([]interface{})
+	updated := []interface{}{}
+	var death amqp.Table
+	for _, d := range deaths {
+		table, ok := d.(amqp.Table)
+		if ok && death == nil && table["queue"] == q.name && table["reason"] == reason {
+			death = copyHeaders(table)
+			continue
+		}
+		updated = append(updated, d)
+	}
+	if death == nil {
+		death = amqp.Table{
+			"count":        int64(0),
+			"reason":       reason,
+			"queue":        q.name,
+			"exchange":     m.exchange,
+			"routing-keys": []interface{}{m.key},
+		}
+	}
+	death["count"] = death["count"].(int64) + 1
+	death["time"] = time.Now()
+	headers["x-death"] = append([]interface{}{death}, updated...)
+
+	dead := m
+	dead.msg.Headers = headers
+	dead.exchange = dlx
+	dead.key = key
+	dead.redelivered = false
+	mb.route(dead)
+}
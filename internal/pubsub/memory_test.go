@@ -0,0 +1,393 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case delivery, ok := <-deliveries:
+		if !ok {
+			t.Fatal("delivery channel closed")
+		}
+		return delivery
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a delivery")
+	}
+	return amqp.Delivery{}
+}
+
+// eventually waits for cond, messages that expire or are dead-lettered arrive a little later
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the broker")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryBrokerRouting(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       string
+		bindingKey string
+		routingKey string
+		routed     bool
+	}{
+		{"topic star matches one word", amqp.ExchangeTopic, "army_moves.*", "army_moves.alice", true},
+		{"topic star does not match two words", amqp.ExchangeTopic, "army_moves.*", "army_moves.alice.bob", false},
+		{"topic star does not match zero words", amqp.ExchangeTopic, "army_moves.*", "army_moves", false},
+		{"topic hash matches zero words", amqp.ExchangeTopic, "game_logs.#", "game_logs", true},
+		{"topic hash matches many words", amqp.ExchangeTopic, "game_logs.#", "game_logs.a.alice", true},
+		{"topic hash in the middle", amqp.ExchangeTopic, "#.alice", "a.game_logs.alice", true},
+		{"topic other prefix", amqp.ExchangeTopic, "army_moves.*", "commands.alice", false},
+		{"direct same key", amqp.ExchangeDirect, "pause", "pause", true},
+		{"direct other key", amqp.ExchangeDirect, "pause", "turn", false},
+		{"direct no patterns", amqp.ExchangeDirect, "*", "pause", false},
+		{"fanout ignores the key", amqp.ExchangeFanout, "", "anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := NewMemoryBroker()
+			defer mb.Close()
+			err := mb.DeclareExchange("test", tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = mb.DeclareQueue("q", TransientQueue, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = mb.BindQueue("q", tt.bindingKey, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = mb.Publish(context.Background(), "test", tt.routingKey, amqp.Publishing{Body: []byte("hi")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			routed := mb.QueueLength("q") == 1
+			if routed != tt.routed {
+				t.Errorf("routed = %v, want %v", routed, tt.routed)
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerRoutesOnceToEveryQueue(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareExchange("test", amqp.ExchangeTopic)
+	for _, q := range []string{"a", "b"} {
+		mb.DeclareQueue(q, TransientQueue, nil)
+	}
+	// two bindings of the same queue that both match still deliver one copy
+	mb.BindQueue("a", "state.*", "test")
+	mb.BindQueue("a", "#", "test")
+	mb.BindQueue("b", "state.alice", "test")
+
+	err := mb.Publish(context.Background(), "test", "state.alice", amqp.Publishing{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mb.QueueLength("a"); got != 1 {
+		t.Errorf("queue a has %v messages, want 1", got)
+	}
+	if got := mb.QueueLength("b"); got != 1 {
+		t.Errorf("queue b has %v messages, want 1", got)
+	}
+}
+
+func TestMemoryBrokerPublishConfirmUnroutable(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareExchange("test", amqp.ExchangeDirect)
+
+	err := mb.PublishConfirm(context.Background(), "test", "nobody", amqp.Publishing{})
+	returned, ok := err.(*ReturnedError)
+	if !ok {
+		t.Fatalf("err = %v, want a *ReturnedError", err)
+	}
+	if returned.ReplyCode != amqp.NoRoute {
+		t.Errorf("reply code = %v, want %v", returned.ReplyCode, amqp.NoRoute)
+	}
+}
+
+// newDeadLetterQueue declares q with peril_dlx as its dead letter exchange and
+// publishes one message to it through the default exchange
+func newDeadLetterQueue(t *testing.T, mb *MemoryBroker, args amqp.Table) {
+	t.Helper()
+	if args == nil {
+		args = amqp.Table{}
+	}
+	args["x-dead-letter-exchange"] = routing.ExchangePerilDLX
+	_, err := mb.DeclareQueue("q", DurableQueue, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mb.Publish(context.Background(), "", "q", amqp.Publishing{
+		ContentType:  ContentTypeJSON,
+		DeliveryMode: amqp.Persistent,
+		Body:         []byte(`"hi"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryBrokerAck(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	newDeadLetterQueue(t, mb, nil)
+
+	deliveries, err := mb.Consume("q", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := receive(t, deliveries)
+	if delivery.DeliveryMode != amqp.Persistent {
+		t.Errorf("delivery mode = %v, want %v", delivery.DeliveryMode, amqp.Persistent)
+	}
+	err = delivery.Ack(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mb.QueueLength("q"); got != 0 {
+		t.Errorf("queue has %v messages after the ack, want 0", got)
+	}
+	if got := mb.QueueLength(routing.QueuePerilDLQ); got != 0 {
+		t.Errorf("dlq has %v messages after the ack, want 0", got)
+	}
+	if err := delivery.Ack(false); err == nil {
+		t.Error("acking the same delivery twice succeeded")
+	}
+}
+
+func TestMemoryBrokerNackRequeue(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	newDeadLetterQueue(t, mb, nil)
+
+	deliveries, err := mb.Consume("q", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := receive(t, deliveries)
+	if first.Redelivered {
+		t.Error("first delivery is marked redelivered")
+	}
+	err = first.Nack(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := receive(t, deliveries)
+	if !second.Redelivered {
+		t.Error("requeued delivery is not marked redelivered")
+	}
+	if string(second.Body) != string(first.Body) {
+		t.Errorf("requeued body = %s, want %s", second.Body, first.Body)
+	}
+	second.Ack(false)
+	if got := mb.QueueLength(routing.QueuePerilDLQ); got != 0 {
+		t.Errorf("dlq has %v messages, want 0", got)
+	}
+}
+
+func TestMemoryBrokerNackDiscardDeadLetters(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	newDeadLetterQueue(t, mb, nil)
+
+	deliveries, err := mb.Consume("q", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = receive(t, deliveries).Nack(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dlq, err := mb.Consume(routing.QueuePerilDLQ, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := parseDeadLetter(receive(t, dlq))
+	if dl.Reason != "rejected" {
+		t.Errorf("reason = %q, want rejected", dl.Reason)
+	}
+	if dl.Queue != "q" {
+		t.Errorf("queue = %q, want q", dl.Queue)
+	}
+	if dl.Exchange != "" || len(dl.RoutingKeys) != 1 || dl.RoutingKeys[0] != "q" {
+		t.Errorf("origin = %q %v, want the default exchange with key q", dl.Exchange, dl.RoutingKeys)
+	}
+	if dl.Count != 1 {
+		t.Errorf("count = %v, want 1", dl.Count)
+	}
+	if dl.Delivery.DeliveryMode != amqp.Persistent {
+		t.Errorf("delivery mode = %v, want %v", dl.Delivery.DeliveryMode, amqp.Persistent)
+	}
+}
+
+func TestMemoryBrokerTTLDeadLetters(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	newDeadLetterQueue(t, mb, amqp.Table{"x-message-ttl": int64(10)})
+
+	eventually(t, func() bool { return mb.QueueLength(routing.QueuePerilDLQ) == 1 })
+	if got := mb.QueueLength("q"); got != 0 {
+		t.Errorf("queue still has %v messages after the ttl, want 0", got)
+	}
+
+	dlq, err := mb.Consume(routing.QueuePerilDLQ, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := parseDeadLetter(receive(t, dlq))
+	if dl.Reason != "expired" {
+		t.Errorf("reason = %q, want expired", dl.Reason)
+	}
+	if dl.Queue != "q" {
+		t.Errorf("queue = %q, want q", dl.Queue)
+	}
+}
+
+func TestMemoryBrokerTTLSparesDeliveredMessages(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	newDeadLetterQueue(t, mb, amqp.Table{"x-message-ttl": int64(10)})
+
+	deliveries, err := mb.Consume("q", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := receive(t, deliveries)
+	time.Sleep(50 * time.Millisecond)
+	err = delivery.Ack(false)
+	if err != nil {
+		t.Fatalf("ack after the ttl failed: %v", err)
+	}
+	if got := mb.QueueLength(routing.QueuePerilDLQ); got != 0 {
+		t.Errorf("dlq has %v messages, want 0", got)
+	}
+}
+
+func TestMemoryBrokerPrefetch(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareQueue("q", TransientQueue, nil)
+	for i := 0; i < 3; i++ {
+		mb.Publish(context.Background(), "", "q", amqp.Publishing{})
+	}
+
+	deliveries, err := mb.Consume("q", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := receive(t, deliveries)
+	receive(t, deliveries)
+	if got := mb.QueueLength("q"); got != 1 {
+		t.Fatalf("queue has %v messages with a prefetch of 2, want 1", got)
+	}
+	first.Ack(false)
+	receive(t, deliveries)
+	if got := mb.QueueLength("q"); got != 0 {
+		t.Errorf("queue has %v messages after an ack, want 0", got)
+	}
+}
+
+func TestSubscribeAckTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		ackType AckType
+		dlq     int
+	}{
+		{"ack", Ack, 0},
+		{"nack discard", NackDiscard, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := NewMemoryBroker()
+			defer mb.Close()
+			mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+			handled := make(chan string, 1)
+			sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(msg string) AckType {
+				handled <- msg
+				return tt.ackType
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close(context.Background())
+
+			err = Publish(context.Background(), mb, routing.ExchangePerilTopic, "test.alice", ContentTypeJSON, "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case msg := <-handled:
+				if msg != "hi" {
+					t.Errorf("handled %q, want hi", msg)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("handler was not called")
+			}
+
+			eventually(t, func() bool { return sub.Stats().Processed == 1 })
+			if got := mb.QueueLength(routing.QueuePerilDLQ); got != tt.dlq {
+				t.Errorf("dlq has %v messages, want %v", got, tt.dlq)
+			}
+		})
+	}
+}
+
+func TestSubscribeNackRequeue(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+	redelivered := make(chan bool, 2)
+	sub, err := SubscribeDelivery(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(_ string, delivery amqp.Delivery) AckType {
+		redelivered <- delivery.Redelivered
+		if delivery.Redelivered {
+			return Ack
+		}
+		return NackRequeue
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	err = Publish(context.Background(), mb, routing.ExchangePerilTopic, "test.alice", ContentTypeJSON, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{false, true} {
+		select {
+		case got := <-redelivered:
+			if got != want {
+				t.Errorf("redelivered = %v, want %v", got, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("handler was not called")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import "time"
+
+const (
+	defaultPrefetch      = 10
+	defaultMaxRetries    = 5
+	defaultMaxRetryDelay = 5 * time.Minute
+)
+
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	poisonPolicy PoisonPolicy
+	workers      int
+	prefetch     int
+	orderedByKey bool
+	maxRetries   int
+	maxDelay     time.Duration
+}
+
+func defaultSubscribeOptions() subscribeOptions {
+	return subscribeOptions{
+		poisonPolicy: PoisonPolicy{Action: PoisonDiscard},
+		workers:      1,
+		maxRetries:   defaultMaxRetries,
+		maxDelay:     defaultMaxRetryDelay,
+	}
+}
+
+// prefetchCount is the explicit prefetch, or enough to keep every worker busy
+// with one delivery waiting behind it.
+func (o subscribeOptions) prefetchCount() int {
+	if o.prefetch > 0 {
+		return o.prefetch
+	}
+	if 2*o.workers > defaultPrefetch {
+		return 2 * o.workers
+	}
+	return defaultPrefetch
+}
+
+// WithPoisonPolicy sets what happens to messages that fail to decode. The default is PoisonDiscard.
+func WithPoisonPolicy(policy PoisonPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.poisonPolicy = policy
+	}
+}
+
+// WithWorkers runs n handlers concurrently instead of one. The prefetch grows to
+// match unless it is set with WithPrefetch.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithOrderedByKey keeps deliveries with the same routing key (e.g. game_logs.<username>)
+// in order by always handing them to the same worker, while different keys still run in parallel.
+func WithOrderedByKey() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderedByKey = true
+	}
+}
+
+// WithMaxRetries is how many times a handler can return Retry for the same message
+// before it is dead-lettered instead. The default is 5.
+func WithMaxRetries(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithMaxRetryDelay caps the exponential backoff of Retry. The default is 5 minutes.
+func WithMaxRetryDelay(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.maxDelay = d
+	}
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PoisonAction is what a subscription does with a delivery it can not decode.
+type PoisonAction int
+
+const (
+	PoisonDiscard    PoisonAction = iota // nack without requeue, so it goes to peril_dlx
+	PoisonRequeue                        // put it back on the queue up to MaxRequeues times, then discard
+	PoisonQuarantine                     // move it to <queue>.quarantine with the decode error in the headers
+)
+
+const (
+	decodeAttemptsHeader = "x-decode-attempts"
+	decodeErrorHeader    = "x-decode-error"
+	originalExchange     = "x-original-exchange"
+	originalRoutingKey   = "x-original-routing-key"
+	quarantineSuffix     = ".quarantine"
+)
+
+type PoisonPolicy struct {
+	Action      PoisonAction
+	MaxRequeues int // only used by PoisonRequeue
+}
+
+// DecodeFailures is the number of deliveries on this subscription's queue that could not be decoded.
+func (s *Subscription) DecodeFailures() uint64 {
+	return s.decodeFailures.Load()
+}
+
+func quarantineQueueName(queueName string) string {
+	return queueName + quarantineSuffix
+}
+
+// handlePoison settles a delivery that could not be decoded according to the poison policy.
+// Every path acks or nacks it, otherwise it would hold one of the prefetch slots forever.
+func (s *Subscription) handlePoison(delivery amqp.Delivery, decodeErr error) {
+	s.decodeFailures.Add(1)
+	log.Printf("Cant Unmarshal message from %v (%v failures so far): %v\n", s.queueName, s.DecodeFailures(), decodeErr)
+
+	switch s.options.poisonPolicy.Action {
+	case PoisonRequeue:
+		attempts := headerInt(delivery.Headers, decodeAttemptsHeader) + 1
+		if attempts > s.options.poisonPolicy.MaxRequeues {
+			delivery.Nack(false, false)
+			log.Println("NackDiscard occured, requeue limit reached")
+			return
+		}
+
+		// requeueing with Nack can not count attempts, so a copy with the count in the headers goes back instead
+		headers := copyHeaders(delivery.Headers)
+		headers[decodeAttemptsHeader] = int32(attempts)
+		err := s.republish(delivery, "", s.queueName, headers)
+		if err != nil {
+			log.Println("Failed to requeue poison message: ", err)
+			delivery.Nack(false, true)
+			return
+		}
+		delivery.Ack(false)
+		log.Println("Poison message requeued, attempt", attempts)
+
+	case PoisonQuarantine:
+		headers := copyHeaders(delivery.Headers)
+		headers[decodeErrorHeader] = decodeErr.Error()
+		err := s.republish(delivery, "", quarantineQueueName(s.queueName), headers)
+		if err != nil {
+			log.Println("Failed to quarantine poison message: ", err)
+			delivery.Nack(false, false)
+			return
+		}
+		delivery.Ack(false)
+		log.Println("Poison message moved to", quarantineQueueName(s.queueName))
+
+	default:
+		delivery.Nack(false, false)
+		log.Println("NackDiscard occured")
+	}
+}
+
+// republish publishes a copy of the delivery, which keeps its persistence and correlation.
+// The copy goes through the default exchange, so the headers remember where the
+// delivery was first published to, a copy of a copy keeps the first one.
+func (s *Subscription) republish(delivery amqp.Delivery, exchange, key string, headers amqp.Table) error {
+	if _, ok := headers[originalExchange]; !ok {
+		headers[originalExchange] = delivery.Exchange
+		headers[originalRoutingKey] = delivery.RoutingKey
+	}
+	return s.broker.Publish(context.Background(), exchange, key, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   delivery.ContentType,
+		DeliveryMode:  delivery.DeliveryMode,
+		CorrelationId: delivery.CorrelationId,
+		ReplyTo:       delivery.ReplyTo,
+		MessageId:     delivery.MessageId,
+		Timestamp:     delivery.Timestamp,
+		Body:          delivery.Body,
+	})
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := amqp.Table{}
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+func headerInt(headers amqp.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
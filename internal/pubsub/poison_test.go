@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestHeaderInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"int32 from the broker", amqp.Table{decodeAttemptsHeader: int32(3)}, 3},
+		{"int64", amqp.Table{decodeAttemptsHeader: int64(4)}, 4},
+		{"int", amqp.Table{decodeAttemptsHeader: 5}, 5},
+		{"missing", amqp.Table{}, 0},
+		{"no headers", nil, 0},
+		{"not a number", amqp.Table{decodeAttemptsHeader: "3"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerInt(tt.headers, decodeAttemptsHeader); got != tt.want {
+				t.Errorf("headerInt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyHeaders(t *testing.T) {
+	headers := amqp.Table{decodeAttemptsHeader: int32(1)}
+	copied := copyHeaders(headers)
+	copied[decodeAttemptsHeader] = int32(2)
+	copied[decodeErrorHeader] = "bad json"
+
+	if headers[decodeAttemptsHeader] != int32(1) || len(headers) != 1 {
+		t.Errorf("the delivery's headers changed to %v", headers)
+	}
+	if len(copyHeaders(nil)) != 0 {
+		t.Error("copying no headers returned some")
+	}
+}
+
+func TestPoisonPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PoisonPolicy
+		// failures is how often the message fails to decode before it is settled for good
+		failures   uint64
+		dlq        int
+		quarantine int
+	}{
+		{"discard", PoisonPolicy{Action: PoisonDiscard}, 1, 1, 0},
+		{"requeue until the limit", PoisonPolicy{Action: PoisonRequeue, MaxRequeues: 2}, 3, 1, 0},
+		{"requeue without requeues", PoisonPolicy{Action: PoisonRequeue}, 1, 1, 0},
+		{"quarantine", PoisonPolicy{Action: PoisonQuarantine}, 1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := NewMemoryBroker()
+			defer mb.Close()
+			mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+			sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(int) AckType {
+				t.Error("the handler got a message that does not decode")
+				return Ack
+			}, WithPoisonPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close(context.Background())
+
+			err = mb.Publish(context.Background(), routing.ExchangePerilTopic, "test.alice", amqp.Publishing{ContentType: ContentTypeJSON, Body: []byte("not json")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			eventually(t, func() bool {
+				return mb.QueueLength(routing.QueuePerilDLQ) == tt.dlq && mb.QueueLength(quarantineQueueName("q")) == tt.quarantine && sub.DecodeFailures() == tt.failures
+			})
+			if got := mb.QueueLength("q"); got != 0 {
+				t.Errorf("%v messages are left in the queue", got)
+			}
+		})
+	}
+}
+
+func TestPoisonRequeueCountsAttempts(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+	sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(int) AckType {
+		return Ack
+	}, WithPoisonPolicy(PoisonPolicy{Action: PoisonRequeue, MaxRequeues: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	err = mb.Publish(context.Background(), routing.ExchangePerilTopic, "test.alice", amqp.Publishing{ContentType: ContentTypeJSON, DeliveryMode: amqp.Persistent, Body: []byte("not json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dlq, err := mb.Consume(routing.QueuePerilDLQ, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := receive(t, dlq)
+	if got := headerInt(delivery.Headers, decodeAttemptsHeader); got != 2 {
+		t.Errorf("dead letter was requeued %v times, want 2", got)
+	}
+	if string(delivery.Body) != "not json" {
+		t.Errorf("dead letter body = %q, want the original", delivery.Body)
+	}
+	if delivery.DeliveryMode != amqp.Persistent {
+		t.Errorf("delivery mode = %v, want %v", delivery.DeliveryMode, amqp.Persistent)
+	}
+	// the requeues went through the default exchange, the dead letter still knows the original route
+	dl := parseDeadLetter(delivery)
+	if dl.Exchange != routing.ExchangePerilTopic || len(dl.RoutingKeys) != 1 || dl.RoutingKeys[0] != "test.alice" {
+		t.Errorf("dead letter replays to %q with keys %v, want %v with test.alice", dl.Exchange, dl.RoutingKeys, routing.ExchangePerilTopic)
+	}
+}
+
+func TestPoisonQuarantineHeaders(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+	sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(int) AckType {
+		return Ack
+	}, WithPoisonPolicy(PoisonPolicy{Action: PoisonQuarantine}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	err = mb.Publish(context.Background(), routing.ExchangePerilTopic, "test.alice", amqp.Publishing{ContentType: ContentTypeJSON, Body: []byte("not json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine, err := mb.Consume(quarantineQueueName("q"), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := receive(t, quarantine)
+	if delivery.Headers[originalExchange] != routing.ExchangePerilTopic || delivery.Headers[originalRoutingKey] != "test.alice" {
+		t.Errorf("quarantined from %v with key %v, want %v with test.alice", delivery.Headers[originalExchange], delivery.Headers[originalRoutingKey], routing.ExchangePerilTopic)
+	}
+	if reason, _ := delivery.Headers[decodeErrorHeader].(string); reason == "" {
+		t.Error("the decode error is missing from the headers")
+	}
+}
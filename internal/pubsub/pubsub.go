@@ -1,11 +1,11 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
+	"hash/fnv"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -18,200 +18,259 @@ const (
 	TransientQueue                  // 1
 )
 
-type AckType int
+type ackKind int
 
 const (
-	Ack         AckType = iota // 0
-	NackRequeue                // 1
-	NackDiscard                // 2
+	ackKindAck         ackKind = iota // 0
+	ackKindNackRequeue                // 1
+	ackKindNackDiscard                // 2
+	ackKindRetry                      // 3
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	data, err := json.Marshal(val)
+// AckType is what a handler wants done with a delivery. It is a struct rather
+// than a plain enum so Retry can carry its delay.
+type AckType struct {
+	kind       ackKind
+	retryAfter time.Duration
+}
+
+var (
+	Ack         = AckType{kind: ackKindAck}
+	NackRequeue = AckType{kind: ackKindNackRequeue}
+	NackDiscard = AckType{kind: ackKindNackDiscard}
+)
+
+// Retry redelivers the message after a delay instead of straight away like NackRequeue.
+// after is the delay of the first retry, it doubles with every further attempt.
+// Once the subscription's max retries are used up the message goes to the DLQ.
+func Retry(after time.Duration) AckType {
+	return AckType{kind: ackKindRetry, retryAfter: after}
+}
+
+// Publish encodes val with the codec registered for contentType and publishes it.
+func Publish[T any](ctx context.Context, b Broker, exchange, key, contentType string, val T) error {
+	msg, err := encode(contentType, val)
 	if err != nil {
 		return err
 	}
 
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        data,
-	})
+	err = b.Publish(ctx, exchange, key, msg)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	data, err := gobEncode(val)
+func encode[T any](contentType string, val T) (amqp.Publishing, error) {
+	codec, err := CodecFor(contentType)
 	if err != nil {
-		return err
+		return amqp.Publishing{}, err
 	}
 
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/gob",
-		Body:        data,
-	})
+	data, err := codec.Marshal(val)
 	if err != nil {
-		return err
+		return amqp.Publishing{}, err
 	}
-	return nil
+
+	return amqp.Publishing{
+		ContentType: contentType,
+		Body:        data,
+	}, nil
 }
 
-func gobEncode(v any) ([]byte, error) {
-	buf := bytes.Buffer{}
-	encoder := gob.NewEncoder(&buf)
+// decode picks the codec by the delivery's content type, so publishers can switch
+// formats without breaking subscribers. Deliveries without a content type are JSON.
+func decode[T any](delivery amqp.Delivery) (T, error) {
+	var message T
 
-	err := encoder.Encode(v)
-	if err != nil {
-		return nil, err
+	contentType := delivery.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
 	}
-	return buf.Bytes(), nil
-}
-
-func GobDecode() func(data []byte) (routing.GameLog, error) {
-	return func(data []byte) (routing.GameLog, error) {
-		var gl routing.GameLog
-		buf := bytes.NewBuffer(data)
-		decoder := gob.NewDecoder(buf)
 
-		err := decoder.Decode(&gl)
-		if err != nil {
-			return routing.GameLog{}, err
-		}
-		return gl, nil
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return message, err
 	}
+
+	err = codec.Unmarshal(delivery.Body, &message)
+	return message, err
 }
 
+// DeclareAndBind declares the queue with peril_dlx as its dead letter exchange and
+// binds it to the exchange.
 func DeclareAndBind(
-	conn *amqp.Connection,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType QueueType, // an enum to represent "durable" or "transient"
-) (*amqp.Channel, amqp.Queue, error) {
-
-	newChan, err := conn.Channel()
-	if err != nil {
-		return nil, amqp.Queue{}, err
-	}
-
-	isDurable := true
-	autoDelete := false
-	exclusive := false
-	if simpleQueueType == 1 {
-		isDurable = false
-		autoDelete = true
-		exclusive = true
-	}
+) (amqp.Queue, error) {
 
 	tab := amqp.Table{}
 	// pass the name of your dead letter exchange as value
-	tab["x-dead-letter-exchange"] = "peril_dlx"
+	tab["x-dead-letter-exchange"] = routing.ExchangePerilDLX
 
-	newQueue, err := newChan.QueueDeclare(queueName, isDurable, autoDelete, exclusive, false, tab)
+	newQueue, err := b.DeclareQueue(queueName, simpleQueueType, tab)
 	if err != nil {
-		return nil, amqp.Queue{}, err
+		return amqp.Queue{}, err
 	}
 
-	err = newChan.QueueBind(queueName, key, exchange, false, nil)
+	err = b.BindQueue(queueName, key, exchange)
 	if err != nil {
-		return nil, amqp.Queue{}, err
+		return amqp.Queue{}, err
 	}
-	return newChan, newQueue, nil
+	return newQueue, nil
 }
 
-func SubscribeJSON[T any](
-	conn *amqp.Connection,
+// Subscribe starts consuming queueName, decoding every delivery with the codec for its
+// content type. On a Connection the consumer survives reconnects with the same handler.
+func Subscribe[T any](
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType QueueType, // an enum to represent "durable" or "transient"
 	handler func(T) AckType,
-) (*amqp.Channel, amqp.Queue, error) {
-	AMQPChann, AMPQQueue, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return SubscribeDelivery(b, exchange, queueName, key, simpleQueueType, func(message T, _ amqp.Delivery) AckType {
+		return handler(message)
+	}, opts...)
+}
+
+// SubscribeDelivery is Subscribe for handlers that also need the delivery,
+// e.g. to tell who sent it from its routing key
+func SubscribeDelivery[T any](
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType QueueType,
+	handler func(T, amqp.Delivery) AckType,
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	options := defaultSubscribeOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	AMPQQueue, err := DeclareAndBind(b, exchange, queueName, key, simpleQueueType)
 	if err != nil {
-		return nil, amqp.Queue{}, err
+		return nil, err
+	}
+
+	if options.poisonPolicy.Action == PoisonQuarantine {
+		_, err = b.DeclareQueue(quarantineQueueName(queueName), DurableQueue, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	AMQPChann.Qos(10, 0, false)
+	sub := &Subscription{
+		Queue:       AMPQQueue,
+		broker:      b,
+		queueName:   queueName,
+		consumerTag: newConsumerTag(queueName),
+		options:     options,
+	}
 
-	delChan, err := AMQPChann.Consume(queueName, "", false, false, false, false, nil)
+	delChan, err := b.Consume(queueName, sub.consumerTag, options.prefetchCount())
 	if err != nil {
-		return nil, amqp.Queue{}, err
+		return nil, err
 	}
 
+	sub.startedAt = time.Now()
+	sub.wg.Add(1)
 	go func() {
-		for delivery := range delChan {
-			var message T
-			err := json.Unmarshal(delivery.Body, &message)
-			if err != nil {
-				log.Println("Cant Unmarshal in goroutine: ", err)
-				continue
-			}
-
-			ackType := handler(message)
-
-			switch ackType {
-			case Ack:
-				delivery.Ack(false)
-				log.Println("Ack occured")
-			case NackRequeue:
-				delivery.Nack(false, true)
-				log.Println("NackRequeue occured")
-			case NackDiscard:
-				delivery.Nack(false, false)
-				log.Println("NackDiscard occured")
-			}
-		}
+		defer sub.wg.Done()
+		consume(sub, delChan, handler)
 	}()
-	return AMQPChann, AMPQQueue, nil
+	return sub, nil
 }
 
-func SubscribeGob[T any](conn *amqp.Connection,
-	exchange,
-	queueName,
-	key string,
-	simpleQueueType QueueType, // an enum to represent "durable" or "transient"
-	handler func(T) AckType,
-	unmarshaller func([]byte) (T, error),
-) (*amqp.Channel, amqp.Queue, error) {
-	AMQPChann, AMPQQueue, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
-	if err != nil {
-		return nil, amqp.Queue{}, err
+// consume hands the deliveries to the subscription's workers and returns once
+// every worker is done with its last delivery.
+func consume[T any](sub *Subscription, delChan <-chan amqp.Delivery, handler func(T, amqp.Delivery) AckType) {
+	workers := sub.options.workers
+	if workers == 1 {
+		for delivery := range delChan {
+			handleDelivery(sub, sub.restoreRoute(delivery), handler)
+		}
+		return
 	}
 
-	err = AMQPChann.Qos(10, 0, false)
-	if err != nil {
-		log.Println("Failed to Qos: ", err)
+	// unordered workers share one channel, ordered ones get a channel each. An ordered
+	// worker's channel holds the whole prefetch, so a slow key never blocks the dispatch
+	// to the other workers: the broker sends no more than that many unacked deliveries.
+	workerChans := make([]chan amqp.Delivery, workers)
+	shared := make(chan amqp.Delivery)
+	for i := range workerChans {
+		workerChans[i] = shared
+		if sub.options.orderedByKey {
+			workerChans[i] = make(chan amqp.Delivery, sub.options.prefetchCount())
+		}
 	}
 
-	delChan, err := AMQPChann.Consume(queueName, "", false, false, false, false, nil)
-	if err != nil {
-		return nil, amqp.Queue{}, err
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(deliveries <-chan amqp.Delivery) {
+			defer wg.Done()
+			for delivery := range deliveries {
+				handleDelivery(sub, delivery, handler)
+			}
+		}(workerChans[i])
 	}
 
-	go func() {
-		for delivery := range delChan {
-			message, err := unmarshaller(delivery.Body)
-			if err != nil {
-				log.Println("Cant Unmarshal in goroutine: ", err)
-				continue
-			}
+	for delivery := range delChan {
+		delivery = sub.restoreRoute(delivery)
+		i := 0
+		if sub.options.orderedByKey {
+			i = workerForKey(delivery.RoutingKey, workers)
+		}
+		workerChans[i] <- delivery
+	}
 
-			ackType := handler(message)
-			switch ackType {
-			case Ack:
-				delivery.Ack(false)
-				log.Println("Ack occured")
-			case NackRequeue:
-				delivery.Nack(false, true)
-				log.Println("NackRequeue occured")
-			case NackDiscard:
-				delivery.Nack(false, false)
-				log.Println("NackDiscard occured")
-			}
+	if sub.options.orderedByKey {
+		for _, ch := range workerChans {
+			close(ch)
 		}
-	}()
-	return AMQPChann, AMPQQueue, nil
+	} else {
+		close(shared)
+	}
+	wg.Wait()
+}
+
+func workerForKey(key string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+func handleDelivery[T any](sub *Subscription, delivery amqp.Delivery, handler func(T, amqp.Delivery) AckType) {
+	sub.inFlight.Add(1)
+	defer sub.inFlight.Add(-1)
+
+	message, err := decode[T](delivery)
+	if err != nil {
+		sub.handlePoison(delivery, err)
+		return
+	}
+
+	ackType := handler(message, delivery)
+	switch ackType.kind {
+	case ackKindAck:
+		delivery.Ack(false)
+		log.Println("Ack occured")
+	case ackKindNackRequeue:
+		delivery.Nack(false, true)
+		log.Println("NackRequeue occured")
+	case ackKindNackDiscard:
+		delivery.Nack(false, false)
+		log.Println("NackDiscard occured")
+	case ackKindRetry:
+		sub.retry(delivery, ackType.retryAfter)
+	}
+	sub.processed.Add(1)
 }
@@ -0,0 +1,171 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type orderedMessage struct {
+	Key string
+	Seq int
+}
+
+// keysOnWorkers returns one routing key per worker, each handled by a different worker
+func keysOnWorkers(t *testing.T, workers int) []string {
+	t.Helper()
+	keys := make([]string, workers)
+	found := 0
+	for i := 0; found < workers && i < 1000; i++ {
+		key := fmt.Sprintf("test.player%v", i)
+		w := workerForKey(key, workers)
+		if keys[w] == "" {
+			keys[w] = key
+			found++
+		}
+	}
+	if found < workers {
+		t.Fatalf("found keys for %v of %v workers", found, workers)
+	}
+	return keys
+}
+
+func newOrderedBroker(t *testing.T) *MemoryBroker {
+	t.Helper()
+	mb := NewMemoryBroker()
+	t.Cleanup(func() { mb.Close() })
+	mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+	return mb
+}
+
+func publishOrdered(t *testing.T, mb *MemoryBroker, key string, seq int) {
+	t.Helper()
+	err := Publish(context.Background(), mb, routing.ExchangePerilTopic, key, ContentTypeJSON, orderedMessage{Key: key, Seq: seq})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrderedByKeyKeepsEachKeyInOrder(t *testing.T) {
+	const workers, perKey = 4, 25
+	mb := newOrderedBroker(t)
+	keys := keysOnWorkers(t, workers)
+
+	mu := sync.Mutex{}
+	handled := map[string][]int{}
+	done := make(chan struct{})
+	total := 0
+	sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(msg orderedMessage) AckType {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[msg.Key] = append(handled[msg.Key], msg.Seq)
+		total++
+		if total == workers*perKey {
+			close(done)
+		}
+		return Ack
+	}, WithWorkers(workers), WithOrderedByKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	for seq := 0; seq < perKey; seq++ {
+		for _, key := range keys {
+			publishOrdered(t, mb, key, seq)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("not every message was handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys {
+		for i, seq := range handled[key] {
+			if seq != i {
+				t.Fatalf("%v was handled in the order %v", key, handled[key])
+			}
+		}
+	}
+}
+
+func TestOrderedByKeyRunsOtherKeysInParallel(t *testing.T) {
+	mb := newOrderedBroker(t)
+	keys := keysOnWorkers(t, 2)
+
+	release := make(chan struct{})
+	unblock := sync.OnceFunc(func() { close(release) })
+	handled := make(chan string, 3)
+	sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(msg orderedMessage) AckType {
+		if msg.Key == keys[0] {
+			<-release
+		}
+		handled <- msg.Key
+		return Ack
+	}, WithWorkers(2), WithOrderedByKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+	// a failed test still lets the blocked worker finish, or Close would wait for it
+	defer unblock()
+
+	// the second message of the blocked key waits for its worker, the other key does not wait behind it
+	publishOrdered(t, mb, keys[0], 0)
+	publishOrdered(t, mb, keys[0], 1)
+	publishOrdered(t, mb, keys[1], 0)
+
+	select {
+	case key := <-handled:
+		if key != keys[1] {
+			t.Fatalf("handled %v while %v was still blocked", key, keys[0])
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("%v waited for the blocked %v", keys[1], keys[0])
+	}
+	unblock()
+	for i := 0; i < 2; i++ {
+		if key := <-handled; key != keys[0] {
+			t.Errorf("handled %v, want %v", key, keys[0])
+		}
+	}
+}
+
+func TestCloseDrainsOrderedWorkers(t *testing.T) {
+	const workers, messages = 3, 30
+	mb := newOrderedBroker(t)
+	keys := keysOnWorkers(t, workers)
+
+	sub, err := Subscribe(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(msg orderedMessage) AckType {
+		time.Sleep(time.Millisecond)
+		return Ack
+	}, WithWorkers(workers), WithOrderedByKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < messages; i++ {
+		publishOrdered(t, mb, keys[i%workers], i)
+	}
+
+	err = sub.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// whatever the workers were given is handled and acked, the rest is still queued
+	stats := sub.Stats()
+	if stats.InFlight != 0 {
+		t.Errorf("%v deliveries are still being handled after Close", stats.InFlight)
+	}
+	if got := int(stats.Processed) + mb.QueueLength("q"); got != messages {
+		t.Errorf("%v handled and %v queued, want %v messages in total", stats.Processed, mb.QueueLength("q"), messages)
+	}
+}
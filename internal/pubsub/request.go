@@ -0,0 +1,162 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// replyErrorHeader carries the error a Serve handler returned instead of a reply
+const replyErrorHeader = "x-reply-error"
+
+var correlationCounter atomic.Uint64
+
+// correlationPrefix keeps the IDs of two processes apart
+var correlationPrefix = fmt.Sprintf("%x", time.Now().UnixNano())
+
+// ErrRequesterClosed is returned by Request once the requester is closed
+var ErrRequesterClosed = errors.New("pubsub: requester closed")
+
+// Requester sends requests with Request and gets the replies on a queue of its own,
+// bound to the exchange with the queue's name as its key.
+type Requester struct {
+	broker      Broker
+	exchange    string
+	replyKey    string
+	consumerTag string
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[string]chan amqp.Delivery
+}
+
+func NewRequester(b Broker, exchange, replyQueue string) (*Requester, error) {
+	_, err := DeclareAndBind(b, exchange, replyQueue, replyQueue, TransientQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Requester{
+		broker:      b,
+		exchange:    exchange,
+		replyKey:    replyQueue,
+		consumerTag: newConsumerTag(replyQueue),
+		pending:     map[string]chan amqp.Delivery{},
+	}
+	deliveries, err := b.Consume(replyQueue, r.consumerTag, defaultPrefetch)
+	if err != nil {
+		return nil, err
+	}
+	go r.receive(deliveries)
+	return r, nil
+}
+
+// receive hands every reply to the request waiting for it, late replies are dropped
+func (r *Requester) receive(deliveries <-chan amqp.Delivery) {
+	for delivery := range deliveries {
+		delivery.Ack(false)
+		r.mu.Lock()
+		reply, ok := r.pending[delivery.CorrelationId]
+		delete(r.pending, delivery.CorrelationId)
+		r.mu.Unlock()
+		if ok {
+			reply <- delivery
+		}
+	}
+}
+
+func (r *Requester) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return r.broker.Cancel(r.consumerTag)
+}
+
+// Request publishes req with confirms and waits for the reply until ctx is done.
+// A request nobody is bound to is reported as a *ReturnedError.
+func Request[Req, Resp any](ctx context.Context, r *Requester, key, contentType string, req Req) (Resp, error) {
+	var resp Resp
+	msg, err := encode(contentType, req)
+	if err != nil {
+		return resp, err
+	}
+	msg.CorrelationId = fmt.Sprintf("%v-%v", correlationPrefix, correlationCounter.Add(1))
+	msg.ReplyTo = r.replyKey
+
+	reply := make(chan amqp.Delivery, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return resp, ErrRequesterClosed
+	}
+	r.pending[msg.CorrelationId] = reply
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, msg.CorrelationId)
+		r.mu.Unlock()
+	}()
+
+	err = r.broker.PublishConfirm(ctx, r.exchange, key, msg)
+	if err != nil {
+		return resp, err
+	}
+
+	select {
+	case delivery := <-reply:
+		if replyErr, ok := delivery.Headers[replyErrorHeader]; ok {
+			return resp, fmt.Errorf("%v", replyErr)
+		}
+		return decode[Resp](delivery)
+	case <-ctx.Done():
+		return resp, ctx.Err()
+	}
+}
+
+// Serve answers requests sent with Request. The reply is encoded like the request and
+// published on exchange with the requester's key, an error from the handler goes back
+// in place of the reply. A reply that can not be published is logged, not retried,
+// since the handler already ran.
+func Serve[Req, Resp any](
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType QueueType,
+	handler func(Req) (Resp, error),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return SubscribeDelivery(b, exchange, queueName, key, simpleQueueType, func(req Req, delivery amqp.Delivery) AckType {
+		if delivery.ReplyTo == "" {
+			log.Println("Request without a reply key on ", queueName)
+			return NackDiscard
+		}
+
+		resp, handlerErr := handler(req)
+		contentType := delivery.ContentType
+		if contentType == "" {
+			contentType = ContentTypeJSON
+		}
+		msg, err := encode(contentType, resp)
+		if err != nil {
+			log.Println("Failed to encode reply: ", err)
+			return NackDiscard
+		}
+		msg.CorrelationId = delivery.CorrelationId
+		if handlerErr != nil {
+			msg.Headers = amqp.Table{replyErrorHeader: handlerErr.Error()}
+		}
+
+		err = b.Publish(context.Background(), exchange, delivery.ReplyTo, msg)
+		if err != nil {
+			log.Println("Failed to publish reply: ", err)
+		}
+		return Ack
+	}, opts...)
+}
@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	retryAttemptsHeader = "x-retry-attempts"
+	defaultRetryDelay   = time.Second
+	// retry queues delete themselves once they have been unused for this long after their delay
+	retryQueueIdleExpiry = time.Minute
+)
+
+func retryQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%v.retry.%vms", queueName, delay.Milliseconds())
+}
+
+// retryDelay doubles the base delay with every attempt after the first
+func retryDelay(base time.Duration, attempt int, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = defaultRetryDelay
+	}
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// retry parks the delivery in a delay queue whose messages expire after the delay and
+// dead-letter straight back into this subscription's queue. The attempt count travels
+// in the headers, and once it is over the limit the delivery goes to the DLQ instead.
+func (s *Subscription) retry(delivery amqp.Delivery, after time.Duration) {
+	attempts := headerInt(delivery.Headers, retryAttemptsHeader) + 1
+	if attempts > s.options.maxRetries {
+		delivery.Nack(false, false)
+		log.Printf("Retry limit of %v reached, NackDiscard occured\n", s.options.maxRetries)
+		return
+	}
+
+	delay := retryDelay(after, attempts, s.options.maxDelay)
+	queueName, err := s.declareRetryQueue(delay)
+	if err != nil {
+		log.Println("Failed to declare retry queue, requeueing instead: ", err)
+		delivery.Nack(false, true)
+		return
+	}
+
+	headers := copyHeaders(delivery.Headers)
+	headers[retryAttemptsHeader] = int32(attempts)
+	err = s.republish(delivery, "", queueName, headers)
+	if err != nil {
+		log.Println("Failed to publish to retry queue, requeueing instead: ", err)
+		delivery.Nack(false, true)
+		return
+	}
+	delivery.Ack(false)
+	log.Printf("Retry occured, attempt %v in %v\n", attempts, delay)
+}
+
+// restoreRoute gives a delivery that came back from a retry or a poison requeue the exchange
+// and routing key it was first published with. It comes back through the default exchange
+// with the queue's name as its key, which would put it on another ordered worker and
+// tell a handler that reads the key the wrong sender. Like the routing key itself, the
+// headers are only as trustworthy as whoever may publish to the queue.
+func (s *Subscription) restoreRoute(delivery amqp.Delivery) amqp.Delivery {
+	if delivery.Exchange != "" || delivery.RoutingKey != s.queueName {
+		return delivery
+	}
+	exchange, ok := delivery.Headers[originalExchange].(string)
+	if !ok {
+		return delivery
+	}
+	key, ok := delivery.Headers[originalRoutingKey].(string)
+	if !ok {
+		return delivery
+	}
+	delivery.Exchange = exchange
+	delivery.RoutingKey = key
+	return delivery
+}
+
+// declareRetryQueue declares the delay queue on every retry. It expires once it has been
+// idle for a while, so remembering that it was declared would publish the next retry
+// to a queue the broker already deleted, which drops the message. Declaring is idempotent.
+func (s *Subscription) declareRetryQueue(delay time.Duration) (string, error) {
+	name := retryQueueName(s.queueName, delay)
+	args := amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": s.queueName,
+		"x-expires":                 (delay + retryQueueIdleExpiry).Milliseconds(),
+	}
+	_, err := s.broker.DeclareQueue(name, DurableQueue, args)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,195 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		attempt int
+		max     time.Duration
+		want    time.Duration
+	}{
+		{"first attempt is the base", time.Second, 1, time.Minute, time.Second},
+		{"second attempt doubles", time.Second, 2, time.Minute, 2 * time.Second},
+		{"fourth attempt", time.Second, 4, time.Minute, 8 * time.Second},
+		{"capped at max", time.Second, 10, 30 * time.Second, 30 * time.Second},
+		{"base above max", 2 * time.Minute, 1, time.Minute, time.Minute},
+		{"no base uses the default", 0, 1, time.Minute, defaultRetryDelay},
+		{"negative base uses the default", -time.Second, 2, time.Minute, 2 * defaultRetryDelay},
+		{"many attempts do not overflow", time.Second, 1000, 5 * time.Minute, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryDelay(tt.base, tt.attempt, tt.max)
+			if got != tt.want {
+				t.Errorf("retryDelay(%v, %v, %v) = %v, want %v", tt.base, tt.attempt, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryQueueName(t *testing.T) {
+	got := retryQueueName("commands", 1500*time.Millisecond)
+	if got != "commands.retry.1500ms" {
+		t.Errorf("retryQueueName = %q, want commands.retry.1500ms", got)
+	}
+}
+
+func TestRetryUntilMaxAttempts(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+	}{
+		{"no retries", 0},
+		{"one retry", 1},
+		{"three retries", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := NewMemoryBroker()
+			defer mb.Close()
+			mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+			calls := atomic.Int64{}
+			attempts := make(chan int, tt.maxRetries+1)
+			sub, err := SubscribeDelivery(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(_ string, delivery amqp.Delivery) AckType {
+				calls.Add(1)
+				attempts <- headerInt(delivery.Headers, retryAttemptsHeader)
+				return Retry(time.Millisecond)
+			}, WithMaxRetries(tt.maxRetries), WithMaxRetryDelay(5*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close(context.Background())
+
+			err = Publish(context.Background(), mb, routing.ExchangePerilTopic, "test.alice", ContentTypeJSON, "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			eventually(t, func() bool { return mb.QueueLength(routing.QueuePerilDLQ) == 1 })
+			if got := calls.Load(); got != int64(tt.maxRetries+1) {
+				t.Errorf("handler was called %v times, want %v", got, tt.maxRetries+1)
+			}
+			for want := 0; want <= tt.maxRetries; want++ {
+				if got := <-attempts; got != want {
+					t.Errorf("call %v saw attempt %v", want+1, got)
+				}
+			}
+
+			// the dead letter still knows where the message was published first
+			dlq, err := mb.Consume(routing.QueuePerilDLQ, "", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dl := parseDeadLetter(receive(t, dlq))
+			if dl.Exchange != routing.ExchangePerilTopic || len(dl.RoutingKeys) != 1 || dl.RoutingKeys[0] != "test.alice" {
+				t.Errorf("origin = %q %v, want %v test.alice", dl.Exchange, dl.RoutingKeys, routing.ExchangePerilTopic)
+			}
+		})
+	}
+}
+
+func TestRetryRedeclaresItsQueue(t *testing.T) {
+	mb := NewMemoryBroker()
+	defer mb.Close()
+	sub := &Subscription{broker: mb, queueName: "q", options: defaultSubscribeOptions()}
+
+	name, err := sub.declareRetryQueue(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the broker deletes the retry queue once it has been idle for a while
+	mb.mu.Lock()
+	delete(mb.queues, name)
+	mb.mu.Unlock()
+
+	_, err = sub.declareRetryQueue(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb.mu.Lock()
+	_, ok := mb.queues[name]
+	mb.mu.Unlock()
+	if !ok {
+		t.Errorf("retry queue %v was not declared again", name)
+	}
+}
+
+func TestRetryKeepsTheRoutingKey(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%v workers", workers), func(t *testing.T) {
+			mb := NewMemoryBroker()
+			defer mb.Close()
+			mb.DeclareExchange(routing.ExchangePerilTopic, amqp.ExchangeTopic)
+
+			routes := make(chan string, 2)
+			sub, err := SubscribeDelivery(mb, routing.ExchangePerilTopic, "q", "test.*", DurableQueue, func(_ string, delivery amqp.Delivery) AckType {
+				routes <- delivery.Exchange + " " + delivery.RoutingKey
+				if headerInt(delivery.Headers, retryAttemptsHeader) == 0 {
+					return Retry(time.Millisecond)
+				}
+				return Ack
+			}, WithWorkers(workers), WithOrderedByKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close(context.Background())
+
+			err = Publish(context.Background(), mb, routing.ExchangePerilTopic, "test.alice", ContentTypeJSON, "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, attempt := range []string{"first", "retried"} {
+				select {
+				case got := <-routes:
+					if want := routing.ExchangePerilTopic + " test.alice"; got != want {
+						t.Errorf("the %v delivery came from %q, want %q", attempt, got, want)
+					}
+				case <-time.After(testTimeout):
+					t.Fatalf("the %v delivery was not handled", attempt)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreRoute(t *testing.T) {
+	original := amqp.Table{originalExchange: routing.ExchangePerilTopic, originalRoutingKey: "test.alice"}
+
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		exchange string
+		key      string
+	}{
+		{"back from a retry", amqp.Delivery{RoutingKey: "q", Headers: original}, routing.ExchangePerilTopic, "test.alice"},
+		{"published on an exchange", amqp.Delivery{Exchange: routing.ExchangePerilTopic, RoutingKey: "test.bob", Headers: original}, routing.ExchangePerilTopic, "test.bob"},
+		{"sent to another queue", amqp.Delivery{RoutingKey: "other", Headers: original}, "", "other"},
+		{"no original route", amqp.Delivery{RoutingKey: "q"}, "", "q"},
+		{"no original key", amqp.Delivery{RoutingKey: "q", Headers: amqp.Table{originalExchange: routing.ExchangePerilTopic}}, "", "q"},
+		{"original key of the wrong type", amqp.Delivery{RoutingKey: "q", Headers: amqp.Table{originalExchange: routing.ExchangePerilTopic, originalRoutingKey: 7}}, "", "q"},
+	}
+
+	sub := &Subscription{queueName: "q"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sub.restoreRoute(tt.delivery)
+			if got.Exchange != tt.exchange || got.RoutingKey != tt.key {
+				t.Errorf("route = %q %q, want %q %q", got.Exchange, got.RoutingKey, tt.exchange, tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var consumerCounter atomic.Uint64
+
+// Subscription is a running consumer returned by Subscribe.
+type Subscription struct {
+	Queue amqp.Queue
+
+	broker      Broker
+	queueName   string
+	consumerTag string
+	options     subscribeOptions
+
+	decodeFailures atomic.Uint64
+	inFlight       atomic.Int64
+	processed      atomic.Uint64
+	startedAt      time.Time
+
+	mu     sync.Mutex
+	closed bool
+
+	// tracks the consumer goroutine, which only returns once its last handler has acked
+	wg sync.WaitGroup
+}
+
+type SubscriptionStats struct {
+	Queue          string
+	Workers        int
+	InFlight       int64   // deliveries being handled right now
+	Processed      uint64  // deliveries handled and settled
+	DecodeFailures uint64  // deliveries that could not be decoded
+	Throughput     float64 // processed deliveries per second since the subscription started
+}
+
+func (s *Subscription) Stats() SubscriptionStats {
+	stats := SubscriptionStats{
+		Queue:          s.queueName,
+		Workers:        s.options.workers,
+		InFlight:       s.inFlight.Load(),
+		Processed:      s.processed.Load(),
+		DecodeFailures: s.decodeFailures.Load(),
+	}
+	elapsed := time.Since(s.startedAt).Seconds()
+	if elapsed > 0 {
+		stats.Throughput = float64(stats.Processed) / elapsed
+	}
+	return stats
+}
+
+func newConsumerTag(queueName string) string {
+	return fmt.Sprintf("%v-%v", queueName, consumerCounter.Add(1))
+}
+
+// Close cancels the consumer and waits for the handlers of deliveries that were
+// already received to finish and ack. If ctx is done first Close gives up waiting;
+// whatever is not acked is requeued by the broker when the connection closes.
+func (s *Subscription) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	err := s.broker.Cancel(s.consumerTag)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
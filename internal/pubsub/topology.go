@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ApplyTopology declares every exchange, queue and binding in t. Declaring what
+// already exists with the same settings is a no-op, so it is safe to run on every start.
+func ApplyTopology(b Broker, t routing.Topology) error {
+	for _, e := range t.Exchanges {
+		err := b.DeclareExchange(e.Name, e.Kind)
+		if err != nil {
+			return fmt.Errorf("could not declare exchange %v: %w", e.Name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		_, err := b.DeclareQueue(q.Name, QueueTypeOf(q), QueueArgs(q))
+		if err != nil {
+			return fmt.Errorf("could not declare queue %v: %w", q.Name, err)
+		}
+	}
+
+	for _, binding := range t.Bindings {
+		err := b.BindQueue(binding.Queue, binding.Key, binding.Exchange)
+		if err != nil {
+			return fmt.Errorf("could not bind queue %v to %v with key %v: %w", binding.Queue, binding.Exchange, binding.Key, err)
+		}
+	}
+	return nil
+}
+
+func QueueTypeOf(q routing.Queue) QueueType {
+	if q.Durable {
+		return DurableQueue
+	}
+	return TransientQueue
+}
+
+// QueueArgs are the x-arguments the queue is declared with
+func QueueArgs(q routing.Queue) amqp.Table {
+	if len(q.Args) == 0 && q.DeadLetterExchange == "" {
+		return nil
+	}
+
+	args := amqp.Table{}
+	for k, v := range q.Args {
+		args[k] = v
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	return args
+}
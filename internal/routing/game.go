@@ -0,0 +1,105 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const maxGameIDLength = 32
+
+// Game is one game on the broker. The exchanges are shared, but every routing key and
+// queue name of a game starts with its ID, so games never see each other's messages.
+// The zero Game has no prefix, it is the one game there was before there were several.
+type Game struct {
+	ID string
+}
+
+// NewGame only takes IDs that are one word of a routing key: letters, digits, - and _.
+// The names of keys are not IDs, or a game's queues could be mistaken for the default game's.
+func NewGame(id string) (Game, error) {
+	if id == "" {
+		return Game{}, nil
+	}
+	switch id {
+	case ArmyMovesPrefix, CommandsPrefix, StatePrefix, PauseKey, TurnKey, MatchKey, DiplomacyPrefix, GameLogSlug, LobbyKey, LobbyReplyPrefix, AllocateKey, SyncKey, SyncReplyPrefix, "default":
+		return Game{}, fmt.Errorf("error: %v can not be a game ID", id)
+	}
+	if len(id) > maxGameIDLength {
+		return Game{}, fmt.Errorf("error: game ID %q is longer than %v characters", id, maxGameIDLength)
+	}
+	for _, r := range id {
+		ok := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+		if !ok {
+			return Game{}, errors.New("error: a game ID can only have letters, digits, - and _")
+		}
+	}
+	return Game{ID: id}, nil
+}
+
+// String is the ID, or default for the zero Game
+func (g Game) String() string {
+	if g.ID == "" {
+		return "default"
+	}
+	return g.ID
+}
+
+// name puts the game's ID in front of a routing key or queue name
+func (g Game) name(parts ...string) string {
+	if g.ID != "" {
+		parts = append([]string{g.ID}, parts...)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (g Game) PauseKey() string {
+	return g.name(PauseKey)
+}
+
+func (g Game) TurnKey() string {
+	return g.name(TurnKey)
+}
+
+func (g Game) MatchKey() string {
+	return g.name(MatchKey)
+}
+
+func (g Game) AllocateKey() string {
+	return g.name(AllocateKey)
+}
+
+func (g Game) SyncKey() string {
+	return g.name(SyncKey)
+}
+
+// ArmyMovesKey is the key of the player who is shown the move, not of the one who moved
+func (g Game) ArmyMovesKey(username string) string {
+	return g.name(ArmyMovesPrefix, username)
+}
+
+func (g Game) CommandsKey(username string) string {
+	return g.name(CommandsPrefix, username)
+}
+
+func (g Game) StateKey(username string) string {
+	return g.name(StatePrefix, username)
+}
+
+func (g Game) DiplomacyKey(username string) string {
+	return g.name(DiplomacyPrefix, username)
+}
+
+func (g Game) GameLogKey(username string) string {
+	return g.name(GameLogSlug, username)
+}
+
+// Sender is the username in a per player key of the game, e.g. bob in commands.bob.
+// Keys of other games or prefixes are not a sender.
+func (g Game) Sender(prefix, key string) (string, bool) {
+	username, ok := strings.CutPrefix(key, g.name(prefix)+".")
+	if !ok || username == "" || strings.Contains(username, ".") {
+		return "", false
+	}
+	return username, true
+}
@@ -0,0 +1,94 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewGame(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"", true},
+		{"test", true},
+		{"game-2_b", true},
+		{strings.Repeat("a", maxGameIDLength), true},
+		{strings.Repeat("a", maxGameIDLength+1), false},
+		{"my.game", false},
+		{"my game", false},
+		{"game*", false},
+		{"#", false},
+		{"default", false},
+		{CommandsPrefix, false},
+		{StatePrefix, false},
+		{ArmyMovesPrefix, false},
+		{PauseKey, false},
+		{GameLogSlug, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			game, err := NewGame(tt.id)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid = %v", err, tt.valid)
+			}
+			if tt.valid && game.ID != tt.id {
+				t.Errorf("ID = %q, want %q", game.ID, tt.id)
+			}
+		})
+	}
+}
+
+func TestGameNames(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"default string", Game{}.String(), "default"},
+		{"string", Game{ID: "test"}.String(), "test"},
+		{"default key", Game{}.CommandsKey("alice"), CommandsPrefix + ".alice"},
+		{"key", Game{ID: "test"}.CommandsKey("alice"), "test." + CommandsPrefix + ".alice"},
+		{"default pause key", Game{}.PauseKey(), PauseKey},
+		{"pause key", Game{ID: "test"}.PauseKey(), "test." + PauseKey},
+		{"sync reply queue", Game{ID: "test"}.SyncReplyQueue("alice").Name, "test." + SyncReplyPrefix + ".alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGameSender(t *testing.T) {
+	tests := []struct {
+		name     string
+		game     Game
+		key      string
+		username string
+		ok       bool
+	}{
+		{"default game", Game{}, CommandsPrefix + ".alice", "alice", true},
+		{"game", Game{ID: "test"}, "test." + CommandsPrefix + ".alice", "alice", true},
+		{"other game", Game{ID: "test"}, "other." + CommandsPrefix + ".alice", "", false},
+		{"game key in the default game", Game{}, "test." + CommandsPrefix + ".alice", "", false},
+		{"default key in a game", Game{ID: "test"}, CommandsPrefix + ".alice", "", false},
+		{"other prefix", Game{}, StatePrefix + ".alice", "", false},
+		{"extra segments", Game{}, CommandsPrefix + ".alice.bob", "", false},
+		{"empty username", Game{}, CommandsPrefix + ".", "", false},
+		{"only the prefix", Game{}, CommandsPrefix, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, ok := tt.game.Sender(CommandsPrefix, tt.key)
+			if username != tt.username || ok != tt.ok {
+				t.Errorf("Sender(%q) = %q, %v, want %q, %v", tt.key, username, ok, tt.username, tt.ok)
+			}
+		})
+	}
+}
@@ -6,8 +6,58 @@ type PlayingState struct {
 	IsPaused bool
 }
 
+type TurnPhase string
+
+const (
+	// TurnPhaseOrders is when players send the orders for the turn, until the deadline
+	TurnPhaseOrders TurnPhase = "orders"
+	// TurnPhaseResolved comes after the orders have been carried out
+	TurnPhaseResolved TurnPhase = "resolved"
+	// TurnPhaseOff means the game went back to real time
+	TurnPhaseOff TurnPhase = "off"
+)
+
+type TurnState struct {
+	Turn     int
+	Phase    TurnPhase
+	Deadline time.Time
+}
+
+type MatchPhase string
+
+const (
+	// MatchPhaseLobby is before the match, players can join but not play
+	MatchPhaseLobby MatchPhase = "lobby"
+	// MatchPhaseStarting counts down to StartsAt
+	MatchPhaseStarting   MatchPhase = "starting"
+	MatchPhaseInProgress MatchPhase = "in_progress"
+	MatchPhaseFinished   MatchPhase = "finished"
+)
+
+type Standing struct {
+	Username  string
+	Units     int
+	Locations int
+	Score     int
+}
+
+// MatchState is announced by the server on every change of phase and again every few seconds
+// for the clients that joined late. Standings are best first and only set once the match is finished.
+type MatchState struct {
+	Phase   MatchPhase
+	Victory string
+	// StartsAt is set while starting, EndsAt once the match started with a time limit
+	StartsAt  time.Time
+	EndsAt    time.Time
+	Winner    string
+	Reason    string
+	Standings []Standing
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
 	Username    string
+	// Game is set by the server that wrote the log, logs from before there were games have none
+	Game string
 }
@@ -3,14 +3,41 @@ package routing
 const (
 	ArmyMovesPrefix = "army_moves"
 
-	WarRecognitionsPrefix = "war"
+	// clients ask the server for changes on commands.<username>, the server
+	// answers each player on state.<username>
+	CommandsPrefix = "commands"
+	StatePrefix    = "state"
 
 	PauseKey = "pause"
 
+	TurnKey = "turn"
+
+	MatchKey = "match"
+
+	// the lobby takes requests on the lobby key and replies on lobby_reply.<username>
+	LobbyKey         = "lobby"
+	LobbyReplyPrefix = "lobby_reply"
+	// the lobby asks the server of a game to set it up on <game>.allocate,
+	// the server replies on lobby_allocate_reply
+	AllocateKey        = "allocate"
+	AllocateReplyQueue = "lobby_allocate_reply"
+	// clients ask the server of their game for their state on <game>.sync,
+	// the server replies on <game>.sync_reply.<username>
+	SyncKey         = "sync"
+	SyncReplyPrefix = "sync_reply"
+
+	// players send diplomacy messages on diplomacy.<username>, everyone hears them
+	DiplomacyPrefix = "diplomacy"
+
 	GameLogSlug = "game_logs"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
+)
+
+const (
+	QueuePerilDLQ = "peril_dlq"
 )
@@ -0,0 +1,232 @@
+package routing
+
+const (
+	ExchangeKindDirect = "direct"
+	ExchangeKindTopic  = "topic"
+	ExchangeKindFanout = "fanout"
+)
+
+// Topology is every exchange, queue and binding Peril needs on the broker.
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+type Exchange struct {
+	Name string
+	Kind string
+}
+
+type Queue struct {
+	Name string
+	// Durable queues survive broker restarts, the others are exclusive to one
+	// connection and deleted with it
+	Durable            bool
+	DeadLetterExchange string
+	Args               map[string]interface{}
+}
+
+type Binding struct {
+	Exchange string
+	Queue    string
+	Key      string
+}
+
+// PerilTopology is the part of the topology every game shares: the exchanges and the dead letters.
+func PerilTopology() Topology {
+	return Topology{
+		Exchanges: []Exchange{
+			{Name: ExchangePerilDirect, Kind: ExchangeKindDirect},
+			{Name: ExchangePerilTopic, Kind: ExchangeKindTopic},
+			{Name: ExchangePerilDLX, Kind: ExchangeKindFanout},
+		},
+		Queues: []Queue{
+			{Name: QueuePerilDLQ, Durable: true},
+		},
+		Bindings: []Binding{
+			{Exchange: ExchangePerilDLX, Queue: QueuePerilDLQ, Key: ""},
+		},
+	}
+}
+
+// Topology is the game's own shared queues, the same for every server and client of the game.
+// It needs the exchanges of PerilTopology.
+func (g Game) Topology() Topology {
+	return Topology{
+		Queues: []Queue{
+			g.GameLogsQueue(),
+			g.CommandsQueue(),
+			g.WorldDiplomacyQueue(),
+			g.AllocateQueue(),
+			g.SyncQueue(),
+		},
+		Bindings: []Binding{
+			g.GameLogsBinding(),
+			g.CommandsBinding(),
+			g.WorldDiplomacyBinding(),
+			g.AllocateBinding(),
+			g.SyncBinding(),
+		},
+	}
+}
+
+// PlayerTopology is the part of the game's topology that belongs to one client.
+func (g Game) PlayerTopology(username string) Topology {
+	return Topology{
+		Queues: []Queue{
+			g.PauseQueue(username),
+			g.ArmyMovesQueue(username),
+			g.StateQueue(username),
+			g.TurnQueue(username),
+			g.DiplomacyQueue(username),
+			g.MatchQueue(username),
+		},
+		Bindings: []Binding{
+			g.PauseBinding(username),
+			g.ArmyMovesBinding(username),
+			g.StateBinding(username),
+			g.TurnBinding(username),
+			g.DiplomacyBinding(username),
+			g.MatchBinding(username),
+		},
+	}
+}
+
+func (g Game) GameLogsQueue() Queue {
+	return Queue{Name: g.name(GameLogSlug), Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) GameLogsBinding() Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.GameLogsQueue().Name, Key: g.name(GameLogSlug, "*")}
+}
+
+func (g Game) CommandsQueue() Queue {
+	return Queue{Name: g.name(CommandsPrefix), Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) CommandsBinding() Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.CommandsQueue().Name, Key: g.name(CommandsPrefix, "*")}
+}
+
+// WorldDiplomacyQueue is the server's copy of the diplomacy messages
+func (g Game) WorldDiplomacyQueue() Queue {
+	return Queue{Name: g.name(DiplomacyPrefix), Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) WorldDiplomacyBinding() Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.WorldDiplomacyQueue().Name, Key: g.name(DiplomacyPrefix, "*")}
+}
+
+// AllocateQueue is where the server of the game takes the lobby's requests
+func (g Game) AllocateQueue() Queue {
+	return Queue{Name: g.name(AllocateKey), Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) AllocateBinding() Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: g.AllocateQueue().Name, Key: g.AllocateKey()}
+}
+
+// SyncQueue is where the server of the game takes the clients' requests for their state
+func (g Game) SyncQueue() Queue {
+	return Queue{Name: g.name(SyncKey), Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) SyncBinding() Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: g.SyncQueue().Name, Key: g.SyncKey()}
+}
+
+// SyncReplyQueue is bound with its own name as the key like LobbyReplyQueue, and like it
+// it is exclusive to the client, so it is not part of any topology
+func (g Game) SyncReplyQueue(username string) Queue {
+	return Queue{Name: g.name(SyncReplyPrefix, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) PauseQueue(username string) Queue {
+	return Queue{Name: g.name(PauseKey, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) PauseBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: g.PauseQueue(username).Name, Key: g.PauseKey()}
+}
+
+func (g Game) TurnQueue(username string) Queue {
+	return Queue{Name: g.name(TurnKey, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) TurnBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: g.TurnQueue(username).Name, Key: g.TurnKey()}
+}
+
+func (g Game) MatchQueue(username string) Queue {
+	return Queue{Name: g.name(MatchKey, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+func (g Game) MatchBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: g.MatchQueue(username).Name, Key: g.MatchKey()}
+}
+
+func (g Game) ArmyMovesQueue(username string) Queue {
+	return Queue{Name: g.name(ArmyMovesPrefix, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+// ArmyMovesBinding only takes the moves the server decided the player can see
+func (g Game) ArmyMovesBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.ArmyMovesQueue(username).Name, Key: g.ArmyMovesKey(username)}
+}
+
+func (g Game) StateQueue(username string) Queue {
+	return Queue{Name: g.name(StatePrefix, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+// StateBinding only takes the player's own key, state deltas are private
+func (g Game) StateBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.StateQueue(username).Name, Key: g.StateKey(username)}
+}
+
+func (g Game) DiplomacyQueue(username string) Queue {
+	return Queue{Name: g.name(DiplomacyPrefix, username), DeadLetterExchange: ExchangePerilDLX}
+}
+
+// DiplomacyBinding takes every player's messages, treaties are public
+func (g Game) DiplomacyBinding(username string) Binding {
+	return Binding{Exchange: ExchangePerilTopic, Queue: g.DiplomacyQueue(username).Name, Key: g.name(DiplomacyPrefix, "*")}
+}
+
+// LobbyTopology is the lobby's request queue. The reply queues are exclusive to each
+// client like the per player queues, so they are not part of it.
+func LobbyTopology() Topology {
+	return Topology{
+		Queues:   []Queue{LobbyQueue()},
+		Bindings: []Binding{LobbyBinding()},
+	}
+}
+
+// LobbyAllocateReplyQueue is where the lobby gets the replies of the games' servers,
+// it is bound with its own name as the key
+func LobbyAllocateReplyQueue() Queue {
+	return Queue{Name: AllocateReplyQueue, DeadLetterExchange: ExchangePerilDLX}
+}
+
+// LobbyQueue is where the lobby takes requests, it is not part of any game
+func LobbyQueue() Queue {
+	return Queue{Name: LobbyKey, Durable: true, DeadLetterExchange: ExchangePerilDLX}
+}
+
+func LobbyBinding() Binding {
+	return Binding{Exchange: ExchangePerilDirect, Queue: LobbyQueue().Name, Key: LobbyKey}
+}
+
+// LobbyReplyQueue is bound with its own name as the key, that is the requests' reply key
+func LobbyReplyQueue(username string) Queue {
+	return Queue{Name: LobbyReplyPrefix + "." + username, DeadLetterExchange: ExchangePerilDLX}
+}
+
+// Merge returns a topology with the entries of both t and other.
+func (t Topology) Merge(other Topology) Topology {
+	return Topology{
+		Exchanges: append(append([]Exchange{}, t.Exchanges...), other.Exchanges...),
+		Queues:    append(append([]Queue{}, t.Queues...), other.Queues...),
+		Bindings:  append(append([]Binding{}, t.Bindings...), other.Bindings...),
+	}
+}